@@ -0,0 +1,53 @@
+package jsonsideload
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Decoder - decodes payloads with options set once, safe for concurrent use.
+// The parsed tags of each struct type are cached for the whole package rather than per Decoder, since they do not
+// depend on the options.
+type Decoder struct {
+	opts *options
+}
+
+// NewDecoder - a Decoder applying the options to every payload
+func NewDecoder(opts ...Option) *Decoder {
+	return &Decoder{opts: newOptions(opts)}
+}
+
+// Decode - Unmarshal with the options of the decoder
+func (d *Decoder) Decode(jsonPayload []byte, model interface{}) error {
+	return d.DecodeContext(context.Background(), jsonPayload, model)
+}
+
+// DecodeContext - UnmarshalContext with the options of the decoder
+func (d *Decoder) DecodeContext(ctx context.Context, jsonPayload []byte, model interface{}) error {
+	return unmarshal(ctx, jsonPayload, model, d.opts)
+}
+
+// DecodeReader - UnmarshalReader with the options of the decoder
+func (d *Decoder) DecodeReader(r io.Reader, model interface{}) error {
+	jsonPayload, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading the payload: %w", err) // a failing reader, not a malformed payload
+	}
+	return unmarshal(context.Background(), jsonPayload, model, d.opts)
+}
+
+// Encoder - encodes models with options set once, safe for concurrent use
+type Encoder struct {
+	opts []Option
+}
+
+// NewEncoder - an Encoder applying the options to every model
+func NewEncoder(opts ...Option) *Encoder {
+	return &Encoder{opts: opts}
+}
+
+// Encode - Marshal with the options of the encoder
+func (e *Encoder) Encode(model interface{}) ([]byte, error) {
+	return Marshal(model, e.opts...)
+}
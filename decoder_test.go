@@ -0,0 +1,55 @@
+package jsonsideload
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoder(t *testing.T) {
+	decoder := NewDecoder(WithIDField("uuid"), WithStrictRelations(true))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gadget := new(Gadget)
+			err := decoder.Decode([]byte(`{"name": "watch", "owner_uuid": "a3f2", "owners": [{"uuid": "a3f2", "name": "Ann"}]}`), gadget)
+			assert.Nil(t, err)
+			assert.Equal(t, "Ann", gadget.Owner.Name)
+		}()
+	}
+	wg.Wait()
+
+	err := decoder.Decode([]byte(`{"name": "watch", "owner_uuid": "b7e1", "owners": []}`), new(Gadget))
+	assert.EqualError(t, err, "no sideloaded owners found with uuid b7e1 for Gadget.Owner")
+}
+
+func TestDecoderStats(t *testing.T) {
+	var stats Stats
+	decoder := NewDecoder(WithIDField("uuid"), WithStats(&stats))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := decoder.Decode([]byte(`{"owner_uuid": "a3f2", "owners": [{"uuid": "a3f2", "name": "Ann"}]}`), new(Gadget))
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	// each decode adds its own counts once, the concurrent ones included
+	assert.Equal(t, Stats{Resolved: 4}, stats)
+
+	var single Stats
+	err := Unmarshal([]byte(`{"owner_uuid": "b7e1", "owners": []}`), new(Gadget), WithIDField("uuid"), WithStats(&single))
+	assert.Nil(t, err)
+	assert.Equal(t, Stats{Misses: 1}, single)
+}
+
+func TestEncoder(t *testing.T) {
+	out, err := NewEncoder(WithIDField("uuid")).Encode(&Gadget{Name: "watch", Owner: &Owner{UUID: "a3f2", Name: "Ann"}})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"name": "watch", "owner_uuid": "a3f2", "owners": [{"uuid": "a3f2", "name": "Ann"}]}`, string(out))
+}
@@ -0,0 +1,124 @@
+package jsonsideload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrExpectPointer - a hasone or include field of a type other than a pointer, struct or interface
+	ErrExpectPointer = errors.New("expecting pointer type")
+	// ErrExpectPointerSlice - a hasmany or includes field other than a slice or array of pointers, structs or interfaces
+	ErrExpectPointerSlice = errors.New("expecting array of pointers")
+)
+
+// UnresolvedRelationError - a hasone or hasmany id without a sideloaded object, returned with WithStrictRelations
+type UnresolvedRelationError struct {
+	// Relation is the name of the sideloaded array searched
+	Relation string
+	// LookupKey is the key of the sideloaded objects matched against the id
+	LookupKey string
+	// ID is the id that matched no sideloaded object
+	ID interface{}
+	// Field is the struct field path of the relationship from the root model, like Order.Items[2]
+	Field string
+}
+
+func (e *UnresolvedRelationError) Error() string {
+	return fmt.Sprintf("no sideloaded %s found with %s %v for %s", e.Relation, e.LookupKey, e.ID, e.Field)
+}
+
+// DuplicateIDError - two sideloaded objects of a relation sharing an id, returned with WithUniqueIDs
+type DuplicateIDError struct {
+	// Relation is the name of the sideloaded array holding the objects
+	Relation string
+	// LookupKey is the key of the sideloaded objects holding the id
+	LookupKey string
+	// ID is the id shared by the objects
+	ID interface{}
+}
+
+func (e *DuplicateIDError) Error() string {
+	return fmt.Sprintf("duplicate sideloaded %s with %s %v", e.Relation, e.LookupKey, e.ID)
+}
+
+// TagError - a jsonsideload tag that does not fit its field, returned by RegisterType
+type TagError struct {
+	// Type is the name of the struct declaring the field
+	Type string
+	// Field is the name of the struct field
+	Field string
+	// Tag is the jsonsideload tag of the field
+	Tag string
+	// Reason is what is wrong with the tag
+	Reason string
+	Err    error
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("%s.%s `jsonsideload:%q`: %s", e.Type, e.Field, e.Tag, e.Reason)
+}
+
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
+
+// TagErrors - every problem found by RegisterType, in the order the fields were walked
+type TagErrors []*TagError
+
+func (e TagErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e TagErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// RelationError - an error decoding an object of a relation, for the most deeply nested relation failing.
+// Its message is the one of Err, which already names the field path.
+type RelationError struct {
+	// Relation is the relation key of the tag, the nested key or the sideloaded array name
+	Relation string
+	// Field is the struct field path of the object from the root model, like Order.Items[2]
+	Field string
+	Err   error
+}
+
+func (e *RelationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RelationError) Unwrap() error {
+	return e.Err
+}
+
+// FieldError - an error decoding the node at Field, the struct field path from the root model like Order.Items[2]
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// atField - err located at the struct field path, nil for a nil err
+func atField(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &FieldError{Field: path, Err: err}
+}
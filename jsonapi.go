@@ -0,0 +1,109 @@
+package jsonsideload
+
+import (
+	"context"
+	"fmt"
+)
+
+// UnmarshalJSONAPI - maps a JSON:API document to the given model, a pointer to struct for a single resource or a
+// pointer to a slice of pointers for an array of them. The resources of the included array are sideloaded under
+// their type, so the relation of a hasone or hasmany tag names a resource type and its id field the relationship,
+// like `jsonsideload:"hasone,people,relationships.author"`.
+func UnmarshalJSONAPI(jsonPayload []byte, model interface{}, opts ...Option) error {
+	source, doc, err := decodeDocument(jsonPayload)
+	if err != nil {
+		return fmt.Errorf("malformed JSON provided: %w", err)
+	}
+	document, ok := source.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("malformed JSON provided: expecting a JSON:API document, got %T", source)
+	}
+	included, ok := document["included"].([]interface{})
+	if !ok && document["included"] != nil {
+		return fmt.Errorf("expecting an array for included, got %T", document["included"])
+	}
+	collections := make(map[string]interface{})
+	for i, resource := range included {
+		resourceMap, ok := resource.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expecting an object at index %d of included, got %T", i, resource)
+		}
+		sideloadResource(collections, resourceMap)
+	}
+
+	o := newOptions(opts)
+	if o.storesErr != nil {
+		return o.storesErr
+	}
+	o.sideloadRoot = "" // the included resources are the sideloaded arrays
+	d := newDecodeState(context.Background(), o, collections)
+	d.document = doc
+	defer o.addStats(&d.stats)
+	switch data := document["data"].(type) {
+	case map[string]interface{}:
+		modelValue, err := rootModel(model)
+		if err != nil {
+			return err
+		}
+		node := sideloadResource(collections, data)
+		return d.result(d.unMarshalNode(node, modelValue, typeName(modelValue.Type().Elem())))
+	case []interface{}:
+		primary := make([]interface{}, len(data))
+		types := make([]string, len(data))
+		for i, resource := range data {
+			primary[i] = resource
+			if resourceMap, ok := resource.(map[string]interface{}); ok {
+				primary[i], types[i] = sideloadResource(collections, resourceMap), fmt.Sprint(resourceMap["type"])
+			}
+		}
+		return d.unMarshalPrimary(primary, "data", func(i int) string { return types[i] }, model)
+	case nil: // an empty to-one document
+		return nil
+	default:
+		return fmt.Errorf("expecting an object or an array for data, got %T", data)
+	}
+}
+
+// sideloadResource - flattens the resource and appends it to the collection of its type
+func sideloadResource(collections map[string]interface{}, resource map[string]interface{}) map[string]interface{} {
+	node := flattenResource(resource)
+	resourceType := fmt.Sprint(resource["type"])
+	collection, _ := collections[resourceType].([]interface{})
+	collections[resourceType] = append(collection, node)
+	return node
+}
+
+// flattenResource - a JSON:API resource object in the shape of a sideloaded one, with its attributes next to its id
+// and the linkage of each relationship reduced to the id, or array of ids, under relationships.<name>
+func flattenResource(resource map[string]interface{}) map[string]interface{} {
+	attributes, _ := resource["attributes"].(map[string]interface{})
+	node := make(map[string]interface{}, len(attributes)+2)
+	for key, value := range attributes {
+		node[key] = value
+	}
+	node["id"] = resource["id"]
+	relationships, ok := resource["relationships"].(map[string]interface{})
+	if !ok {
+		return node
+	}
+	ids := make(map[string]interface{}, len(relationships))
+	for name, relationship := range relationships {
+		relationshipMap, _ := relationship.(map[string]interface{})
+		switch linkage := relationshipMap["data"].(type) {
+		case map[string]interface{}:
+			ids[name] = linkage["id"]
+		case []interface{}:
+			linkageIDs := make([]interface{}, 0, len(linkage))
+			for _, identifier := range linkage {
+				if identifierMap, ok := identifier.(map[string]interface{}); ok {
+					linkageIDs = append(linkageIDs, identifierMap["id"])
+				}
+			}
+			ids[name] = linkageIDs
+		default: // a null to-one linkage, or relationships given by links only
+			ids[name] = nil
+		}
+	}
+	node["relationships"] = ids
+	return node
+}
@@ -0,0 +1,63 @@
+package jsonsideload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonAPIIncluded = `"included": [
+	{"type": "people", "id": "9", "attributes": {"name": "Dan"}},
+	{"type": "comments", "id": "5", "attributes": {"body": "First!"}, "relationships": {"author": {"data": {"type": "people", "id": "2"}}}},
+	{"type": "comments", "id": "12", "attributes": {"body": "I like XML better"}, "relationships": {"author": {"data": {"type": "people", "id": "9"}}}},
+	{"type": "people", "id": "2", "attributes": {"name": "Ann"}}
+]`
+
+func TestUnmarshalJSONAPI(t *testing.T) {
+	data := []byte(`{
+		"data": {
+			"type": "articles",
+			"id": "1",
+			"attributes": {"title": "JSON:API paints my bikeshed!"},
+			"relationships": {
+				"author": {"links": {"related": "/articles/1/author"}, "data": {"type": "people", "id": "9"}},
+				"comments": {"data": [{"type": "comments", "id": "5"}, {"type": "comments", "id": "12"}]}
+			}
+		},
+		` + jsonAPIIncluded + `
+	}`)
+	story := new(Story)
+	err := UnmarshalJSONAPI(data, story)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", story.ID)
+	assert.Equal(t, "JSON:API paints my bikeshed!", story.Title)
+	assert.Equal(t, "Dan", story.Author.Name)
+	if assert.Len(t, story.Remarks, 2) {
+		assert.Equal(t, "First!", story.Remarks[0].Body)
+		assert.Equal(t, "Ann", story.Remarks[0].Author.Name)
+		assert.Same(t, story.Author, story.Remarks[1].Author)
+	}
+}
+
+func TestUnmarshalJSONAPICollection(t *testing.T) {
+	data := []byte(`{
+		"data": [
+			{"type": "articles", "id": "1", "attributes": {"title": "One"}, "relationships": {"author": {"data": {"type": "people", "id": "2"}}}},
+			{"type": "articles", "id": "2", "attributes": {"title": "Two"}, "relationships": {"author": {"data": null}}}
+		],
+		` + jsonAPIIncluded + `
+	}`)
+	var stories []*Story
+	err := UnmarshalJSONAPI(data, &stories)
+	assert.Nil(t, err)
+	if assert.Len(t, stories, 2) {
+		assert.Equal(t, "Ann", stories[0].Author.Name)
+		assert.Equal(t, "Two", stories[1].Title)
+		assert.Nil(t, stories[1].Author)
+	}
+
+	err = UnmarshalJSONAPI([]byte(`{"data": null}`), new(Story))
+	assert.Nil(t, err)
+	err = UnmarshalJSONAPI([]byte(`{"data": 1}`), new(Story))
+	assert.EqualError(t, err, "expecting an object or an array for data, got json.Number")
+}
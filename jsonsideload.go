@@ -1,193 +1,1918 @@
 package jsonsideload
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"sort"
 	"strings"
 )
 
-// Unmarshal - maps sideloaded JSON to the given model
-func Unmarshal(jsonPayload []byte, model interface{}) error {
-	var sourceMap map[string]interface{}
-	err := json.Unmarshal(jsonPayload, &sourceMap)
+// Unmarshal - maps sideloaded JSON to the given model.
+// A top level array is decoded into a pointer to a slice, each element sideloading its own relations.
+func Unmarshal(jsonPayload []byte, model interface{}, opts ...Option) error {
+	return UnmarshalContext(context.Background(), jsonPayload, model, opts...)
+}
+
+// UnmarshalContext - Unmarshal, returning ctx.Err() as soon as the context is done
+func UnmarshalContext(ctx context.Context, jsonPayload []byte, model interface{}, opts ...Option) error {
+	return NewDecoder(opts...).DecodeContext(ctx, jsonPayload, model)
+}
+
+// UnmarshalReader - Unmarshal reading the payload from r, like an HTTP response body. The whole document is still
+// read before decoding, since relations may point anywhere in it.
+func UnmarshalReader(r io.Reader, model interface{}, opts ...Option) error {
+	return NewDecoder(opts...).DecodeReader(r, model)
+}
+
+// UnmarshalWithSource - Unmarshal, also returning the parsed payload for reading what the model leaves out without
+// parsing it again. Numbers are json.Number values, and the map is nil when the payload is a top level array.
+func UnmarshalWithSource(jsonPayload []byte, model interface{}, opts ...Option) (map[string]interface{}, error) {
+	source, doc, err := decodeDocument(jsonPayload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed JSON provided: %w", err)
+	}
+	root, _ := source.(map[string]interface{})
+	return root, decodeSource(context.Background(), source, doc, model, newOptions(opts))
+}
+
+// UnmarshalMap - Unmarshal from a payload already parsed into maps and slices, by a decoder of another format like
+// YAML. Maps keyed by other types than string and numbers of any Go type are read like their JSON counterparts.
+func UnmarshalMap(source interface{}, model interface{}, opts ...Option) error {
+	source, err := normalizeSource(source)
+	if err != nil {
+		return fmt.Errorf("malformed payload provided: %w", err)
+	}
+	return decodeSource(context.Background(), source, nil, model, newOptions(opts))
+}
+
+// unmarshal - decodes the payload into the model with the options
+func unmarshal(ctx context.Context, jsonPayload []byte, model interface{}, o *options) error {
+	source, doc, err := decodeDocument(jsonPayload)
+	if err != nil {
+		return fmt.Errorf("malformed JSON provided: %w", err)
+	}
+	return decodeSource(ctx, source, doc, model, o)
+}
+
+// decodeSource - decodes the parsed payload into the model with the options, doc being the document of the payload
+// and nil for payloads not parsed from JSON text
+func decodeSource(ctx context.Context, source interface{}, doc *document, model interface{}, o *options) error {
+	if o.storesErr != nil {
+		return o.storesErr
+	}
+	source, err := payloadRoot(source, o)
+	if err != nil {
+		return err
+	}
+	switch root := source.(type) {
+	case map[string]interface{}:
+		modelValue, err := rootModel(model)
+		if err != nil {
+			return err
+		}
+		d := newDecodeState(ctx, o, root)
+		d.document = doc
+		defer o.addStats(&d.stats)
+		return d.result(d.unMarshalNode(root, modelValue, typeName(modelValue.Type().Elem())))
+	case []interface{}:
+		return unMarshalArray(ctx, o, root, doc, model)
+	}
+	return fmt.Errorf("malformed JSON provided: expecting an object or an array, got %T", source)
+}
+
+// payloadRoot - the document under the root key of the options in the parsed payload, the payload itself without one
+func payloadRoot(source interface{}, o *options) (interface{}, error) {
+	if o.rootKey == "" {
+		return source, nil
+	}
+	root, ok := source.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("malformed JSON provided: expecting an object holding %s, got %T", o.rootKey, source)
+	}
+	document, ok := root[o.rootKey]
+	if !ok {
+		return nil, fmt.Errorf("root key %s not found in the payload", o.rootKey)
+	}
+	return document, nil
+}
+
+// DecodeInto - Unmarshal into a reused model, first zeroing its jsonsideload tagged fields so that relations
+// the payload leaves out do not keep the values of a previous decode
+func DecodeInto(jsonPayload []byte, model interface{}, opts ...Option) error {
+	if modelValue := reflect.ValueOf(model); modelValue.Kind() == reflect.Ptr && !modelValue.IsNil() && modelValue.Elem().Kind() == reflect.Struct {
+		resetRelations(modelValue.Elem())
+	}
+	return Unmarshal(jsonPayload, model, opts...)
+}
+
+// resetRelations - zeroes the tagged relation fields of the struct
+func resetRelations(modelValue reflect.Value) {
+	for _, field := range typePlan(modelValue.Type()) {
+		if fieldValue, ok := lookupField(modelValue, field.index); ok && fieldValue.CanSet() {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		}
+	}
+}
+
+// UnmarshalMany - decodes each object of the primaryKey array of the payload into the slice of pointers models
+// points to, resolving their relations against the arrays sideloaded in the whole payload
+func UnmarshalMany(jsonPayload []byte, primaryKey string, models interface{}, opts ...Option) error {
+	source, doc, err := decodeDocument(jsonPayload)
+	if err != nil {
+		return fmt.Errorf("malformed JSON provided: %w", err)
+	}
+	o := newOptions(opts)
+	if o.storesErr != nil {
+		return o.storesErr
+	}
+	if source, err = payloadRoot(source, o); err != nil {
+		return err
+	}
+	root, ok := source.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("malformed JSON provided: expecting an object, got %T", source)
+	}
+	primary, ok := root[primaryKey].([]interface{})
+	if !ok && root[primaryKey] != nil {
+		return fmt.Errorf("expecting an array for %s, got %T", primaryKey, root[primaryKey])
+	}
+	d := newDecodeState(context.Background(), o, root)
+	d.document = doc
+	defer o.addStats(&d.stats)
+	return d.unMarshalPrimary(primary, primaryKey, func(int) string { return primaryKey }, models)
+}
+
+// UnmarshalByID - decodes the object of the collection with the id into the given model, a pointer to struct,
+// resolving its relations against the whole payload. For normalized payloads like {"result": 42, "orders": [...]}.
+func UnmarshalByID(jsonPayload []byte, collection string, id interface{}, model interface{}, opts ...Option) error {
+	source, doc, err := decodeDocument(jsonPayload)
+	if err != nil {
+		return fmt.Errorf("malformed JSON provided: %w", err)
+	}
+	o := newOptions(opts)
+	if o.storesErr != nil {
+		return o.storesErr
+	}
+	if source, err = payloadRoot(source, o); err != nil {
+		return err
+	}
+	root, ok := source.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("malformed JSON provided: expecting an object, got %T", source)
+	}
+	modelValue, err := rootModel(model)
+	if err != nil {
+		return err
+	}
+	d := newDecodeState(context.Background(), o, root)
+	d.document = doc
+	defer o.addStats(&d.stats)
+	canonical, ok := canonicalID(id)
+	if !ok { // ints and the other numbers of Go code
+		canonical = canonicalNumber(fmt.Sprint(id))
+	}
+	node, err := d.getValueFromSourceJSON(collection, d.opts.idField, canonical)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("no %s found with %s %v", collection, d.opts.idField, id)
+	}
+	// registered like a sideloaded object, so relations pointing back at it share the model
+	d.instances[instanceKey{collection, canonical, modelValue.Type()}] = modelValue
+	return d.result(d.unMarshalNode(node, modelValue, typeName(modelValue.Type().Elem())))
+}
+
+// unMarshalPrimary - decodes the primary objects, found under name, into the slice of pointers models points to.
+// Each object is registered like a sideloaded object of collection(i), so relations pointing back at it share it.
+func (d *decodeState) unMarshalPrimary(primary []interface{}, name string, collection func(i int) string,
+	models interface{}) error {
+	model := reflect.ValueOf(models)
+	if model.Kind() != reflect.Ptr || model.Elem().Kind() != reflect.Slice || model.Type().Elem().Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("expecting pointer to a slice of pointers for %s, got %T", name, models)
+	}
+	elementType := model.Type().Elem().Elem()
+	elements := reflect.MakeSlice(model.Type().Elem(), 0, len(primary))
+	for i, element := range primary {
+		elementMap, ok := element.(map[string]interface{})
+		if !ok {
+			return d.result(fmt.Errorf("expecting an object at index %d of %s, got %T", i, name, element))
+		}
+		path := indexPath(typeName(elementType.Elem()), i)
+		var m reflect.Value
+		var err error
+		if id, ok := canonicalID(elementMap[d.opts.idField]); ok {
+			m, err = d.unMarshalSideloaded(instanceKey{collection(i), id, elementType}, elementMap, path)
+		} else {
+			m = reflect.New(elementType.Elem())
+			err = d.unMarshalNode(elementMap, m, path)
+		}
+		if err != nil {
+			if d.collect(err) {
+				continue
+			}
+			return d.result(err)
+		}
+		elements = reflect.Append(elements, m)
+	}
+	model.Elem().Set(elements)
+	return d.result(nil)
+}
+
+// rootModel - the pointer to struct an object payload is decoded into, following the pointers of a **T model and
+// allocating the nil ones
+func rootModel(model interface{}) (reflect.Value, error) {
+	modelValue := reflect.ValueOf(model)
+	if modelValue.Kind() != reflect.Ptr || modelValue.IsNil() {
+		return modelValue, fmt.Errorf("model must be a non-nil pointer to struct, got %T", model)
+	}
+	for modelValue.Elem().Kind() == reflect.Ptr {
+		if modelValue.Elem().IsNil() {
+			modelValue.Elem().Set(reflect.New(modelValue.Elem().Type().Elem()))
+		}
+		modelValue = modelValue.Elem()
+	}
+	if _, ok := modelValue.Interface().(json.Unmarshaler); !ok && modelValue.Elem().Kind() != reflect.Struct {
+		return modelValue, fmt.Errorf("model must be a non-nil pointer to struct, got %T", model)
+	}
+	return modelValue, nil
+}
+
+// unMarshalArray - decodes each object of a top level array into an element of the slice model points to
+func unMarshalArray(ctx context.Context, o *options, root []interface{}, doc *document, target interface{}) error {
+	model := reflect.ValueOf(target)
+	if model.Kind() != reflect.Ptr || model.IsNil() || model.Elem().Kind() != reflect.Slice ||
+		model.Type().Elem().Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("expecting pointer to a slice of pointers for a top level array, got %T", target)
+	}
+	elementType := model.Type().Elem().Elem()
+	models := reflect.MakeSlice(model.Type().Elem(), 0, len(root))
+	var errs []error // kept with WithContinueOnError, each element being decoded with its own state
+	var stats Stats
+	defer o.addStats(&stats)
+	for i, element := range root {
+		elementMap, ok := element.(map[string]interface{})
+		if !ok {
+			return errors.Join(append(errs, fmt.Errorf("expecting an object at index %d of the top level array", i))...)
+		}
+		m := reflect.New(elementType.Elem())
+		d := newDecodeState(ctx, o, elementMap)
+		d.document = doc
+		err := d.unMarshalNode(elementMap, m, indexPath(typeName(elementType.Elem()), i))
+		stats.add(d.stats)
+		if err != nil && !d.collect(err) {
+			return errors.Join(append(append(errs, d.errs...), err)...)
+		}
+		errs = append(errs, d.errs...)
+		if err == nil {
+			models = reflect.Append(models, m)
+		}
+	}
+	model.Elem().Set(models)
+	return errors.Join(errs...)
+}
+
+// decodeSourceJSON - decodes the payload keeping numbers as json.Number, so large ids keep their precision
+func decodeSourceJSON(r io.Reader) (interface{}, error) {
+	var source interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&source); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after top-level value")
+	}
+	return source, nil
+}
+
+// keyOrders - the keys of the payload objects holding several keys equal under case folding, in document order,
+// by object. Matching these keys to fields case insensitively picks the last of them, as encoding/json does.
+type keyOrders map[uintptr][]string
+
+// of - the key order of the object, nil if none was recorded
+func (orders keyOrders) of(object map[string]interface{}) []string {
+	if len(orders) == 0 {
+		return nil
+	}
+	return orders[reflect.ValueOf(object).Pointer()]
+}
+
+// document - a parsed payload along with its text, for decoding what depends on how the payload is written
+type document struct {
+	source  interface{}
+	payload []byte
+	// orders holds the key order of the objects with keys equal under case folding
+	orders keyOrders
+	// spans locates the text of the objects and arrays of the payload, recorded on first use
+	spans map[uintptr]span
+}
+
+// span - the offsets of a value in the payload text
+type span struct {
+	start, end int64
+}
+
+// decodeDocument - decodeSourceJSON, also returning the document of the payload. The key orders of the objects
+// with keys equal under case folding are read in a second pass over the payload, only when it has such objects.
+func decodeDocument(jsonPayload []byte) (interface{}, *document, error) {
+	source, err := decodeSourceJSON(bytes.NewReader(jsonPayload))
+	if err != nil {
+		return nil, nil, err
+	}
+	doc := &document{source: source, payload: jsonPayload}
+	if !hasFoldedKeys(source) {
+		return source, doc, nil
+	}
+	doc.orders = make(keyOrders)
+	decoder := json.NewDecoder(bytes.NewReader(jsonPayload))
+	decoder.UseNumber()
+	return source, doc, recordKeyOrders(decoder, source, doc.orders)
+}
+
+// keyOrder - the key order of the object, nil if none was recorded
+func (doc *document) keyOrder(object map[string]interface{}) []string {
+	if doc == nil {
+		return nil
+	}
+	return doc.orders.of(object)
+}
+
+// text - the text of the value, an object or array of the decoded payload found by identity, as written in the
+// payload; nil for values the payload does not hold, like the objects of resolvers
+func (doc *document) text(value interface{}) json.RawMessage {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if doc == nil || v == nil {
+			return nil
+		}
+	case []interface{}:
+		if doc == nil || len(v) == 0 { // empty arrays do not tell apart
+			return nil
+		}
+	default:
+		return nil
+	}
+	if doc.spans == nil {
+		doc.spans = make(map[uintptr]span)
+		decoder := json.NewDecoder(bytes.NewReader(doc.payload))
+		decoder.UseNumber()
+		if err := recordSpans(decoder, doc.source, doc.spans); err != nil {
+			return nil
+		}
+	}
+	found, ok := doc.spans[reflect.ValueOf(value).Pointer()]
+	if !ok {
+		return nil
+	}
+	return append(json.RawMessage(nil), doc.payload[found.start:found.end]...)
+}
+
+// memberText - the text of the value under the key of the object, or at the index of the array, as written in the
+// payload; nil like text for containers the payload does not hold
+func (doc *document) memberText(container interface{}, key string, index int) json.RawMessage {
+	containerText := doc.text(container)
+	if containerText == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(containerText))
+	if _, err := decoder.Token(); err != nil {
+		return nil
+	}
+	var found json.RawMessage
+	for i := 0; decoder.More(); i++ {
+		member := index == i
+		if index < 0 {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil
+			}
+			member = token == key
+		}
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil
+		}
+		if member { // a repeated key holds its last value
+			found = raw
+		}
+	}
+	return found
+}
+
+// hasFoldedKeys - reports whether an object of the decoded value holds several keys equal under case folding
+func hasFoldedKeys(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if foldsKeys(v) {
+			return true
+		}
+		for _, child := range v {
+			if hasFoldedKeys(child) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if hasFoldedKeys(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// foldsKeys - reports whether the object holds several keys equal under case folding
+func foldsKeys(object map[string]interface{}) bool {
+	var folded map[string]bool
+	for key := range object {
+		lower := strings.ToLower(key)
+		if lower == key {
+			continue
+		}
+		if _, ok := object[lower]; ok || folded[lower] {
+			return true
+		}
+		if folded == nil {
+			folded = make(map[string]bool)
+		}
+		folded[lower] = true
+	}
+	return false
+}
+
+// recordSpans - reads the next value of the decoder token by token, recording the span of every object and array
+// in it by the identity of its decoded form. The decoded forms are found in the payload again by key and index.
+func recordSpans(decoder *json.Decoder, value interface{}, spans map[uintptr]span) error {
+	token, err := decoder.Token()
 	if err != nil {
-		return errors.New("malformed JSON provided")
+		return err
+	}
+	start := decoder.InputOffset() - 1 // the offset past the opening delimiter
+	var recorded interface{}
+	switch token {
+	case json.Delim('{'):
+		object, _ := value.(map[string]interface{})
+		for decoder.More() {
+			if token, err = decoder.Token(); err != nil {
+				return err
+			}
+			key, _ := token.(string)
+			// a repeated key holds its last value, whose walk comes last and so wins
+			if err := recordSpans(decoder, object[key], spans); err != nil {
+				return err
+			}
+		}
+		if object != nil {
+			recorded = object
+		}
+	case json.Delim('['):
+		array, _ := value.([]interface{})
+		for i := 0; decoder.More(); i++ {
+			var element interface{}
+			if i < len(array) {
+				element = array[i]
+			}
+			if err := recordSpans(decoder, element, spans); err != nil {
+				return err
+			}
+		}
+		if len(array) > 0 {
+			recorded = array
+		}
+	default:
+		return nil
+	}
+	if _, err := decoder.Token(); err != nil { // the closing delimiter
+		return err
+	}
+	if recorded != nil {
+		spans[reflect.ValueOf(recorded).Pointer()] = span{start, decoder.InputOffset()}
+	}
+	return nil
+}
+
+// recordKeyOrders - reads the next value of the decoder token by token alongside its decoded form, recording the
+// key order of its objects with keys equal under case folding
+func recordKeyOrders(decoder *json.Decoder, value interface{}, orders keyOrders) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		object, _ := value.(map[string]interface{})
+		var keys []string
+		for decoder.More() {
+			if token, err = decoder.Token(); err != nil {
+				return err
+			}
+			key, _ := token.(string)
+			keys = append(keys, key)
+			// a repeated key holds its last value, whose walk comes last and so wins
+			if err := recordKeyOrders(decoder, object[key], orders); err != nil {
+				return err
+			}
+		}
+		if object != nil && foldsKeys(object) {
+			orders[reflect.ValueOf(object).Pointer()] = keys
+		}
+	case json.Delim('['):
+		array, _ := value.([]interface{})
+		for i := 0; decoder.More(); i++ {
+			var element interface{}
+			if i < len(array) {
+				element = array[i]
+			}
+			if err := recordKeyOrders(decoder, element, orders); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	_, err = decoder.Token() // the closing delimiter
+	return err
+}
+
+const (
+	annotationJSONSideload    = "jsonsideload"
+	annotationInclude         = "include"
+	annotationIncludes        = "includes"
+	annotationIncludeFirst    = "include_first"
+	annotationIncludeLast     = "include_last"
+	annotationHasOneRelation  = "hasone"
+	annotationHasManyRelation = "hasmany"
+	annotationHasManyReverse  = "hasmany_reverse"
+	annotationFlatten         = "flatten"
+	annotationRaw             = "raw"
+	annotationCount           = "count"
+	annotationRest            = "rest"
+	annotationZip             = "zip"
+
+	// defaultLookupKey is the key on a sideloaded object matched against the relation id
+	defaultLookupKey = "id"
+	// compoundSeparator joins the fields of a compound id, like tenant_id+account_id
+	compoundSeparator = "+"
+	// candidateSeparator joins the collections a hasone is searched in, like users|organizations
+	candidateSeparator = "|"
+	// selfRelation is the relation key of an include decoding the node itself into another type
+	selfRelation = "."
+	// modifierSeparator sets the modifiers of a tag apart from its arguments, like hasone,shipments,shipment_id;if=has_shipment
+	modifierSeparator = ";"
+)
+
+// decodeState - the state of a single Unmarshal call
+type decodeState struct {
+	ctx       context.Context
+	opts      *options
+	sourceMap map[string]interface{}
+	// depth is the number of relations walked to reach the node being decoded
+	depth int
+	// instances holds every sideloaded object decoded so far, including the ones still being decoded
+	instances map[instanceKey]reflect.Value
+	// indexes holds the sideloaded objects of each relation by id, built on first lookup
+	indexes map[indexKey]map[string]map[string]interface{}
+	// backReferences holds the sideloaded objects of each relation by the id they point back to, built on first lookup
+	backReferences map[indexKey]map[string][]map[string]interface{}
+	// selfViews holds the nodes being decoded by an include of the node itself, by the type they are decoded into
+	selfViews map[selfView]bool
+	// duplicates holds the ids shared by several sideloaded objects of each relation, with unique hasone ids
+	duplicates map[indexKey]map[string]bool
+	// indexErrs holds the DuplicateIDError of each index of a relation with duplicate ids, with unique ids
+	indexErrs map[indexKey]error
+	// stats counts the relation lookups of the call, added to the Stats of the options once it ends
+	stats Stats
+	// document is the document of the payload, nil for payloads not parsed from JSON text
+	document *document
+	// errs holds the errors of the relation elements left out with WithContinueOnError
+	errs []error
+}
+
+func newDecodeState(ctx context.Context, o *options, sourceMap map[string]interface{}) *decodeState {
+	if o.sideloadRoot != "" { // the sideloaded arrays are looked up under the root rather than at the top level
+		sourceMap, _ = valueAtPath(sourceMap, strings.Split(o.sideloadRoot, ".")).(map[string]interface{})
+	}
+	return &decodeState{
+		ctx:       ctx,
+		opts:      o,
+		sourceMap: sourceMap,
+		instances: make(map[instanceKey]reflect.Value),
+		indexes:   make(map[indexKey]map[string]map[string]interface{}),
+
+		backReferences: make(map[indexKey]map[string][]map[string]interface{}),
+	}
+}
+
+// selfView - identifies a node being decoded into a type by an include of the node itself
+type selfView struct {
+	node      uintptr
+	modelType reflect.Type
+}
+
+// indexKey - identifies the index of a relation's sideloaded objects by the key they are looked up with
+type indexKey struct {
+	relation  string
+	lookupKey string
+}
+
+// instanceKey - identifies a sideloaded object decoded into a given type by its relation and id
+type instanceKey struct {
+	relation string
+	// id is the canonical id, see canonicalID
+	id        string
+	modelType reflect.Type
+}
+
+// LoadedSetter - implemented by models wanting to know which of their relation fields were populated, like for
+// PATCH semantics. SetLoaded is called once the relations of the decoded object are walked, with the Go names of
+// the relation fields holding a decoded object, empty ones and ids without a sideloaded object being left out.
+type LoadedSetter interface {
+	SetLoaded(fields []string)
+}
+
+// unMarshalNode - decodes mapToParse into model, path being the struct field path of the node from the root model
+func (d *decodeState) unMarshalNode(mapToParse map[string]interface{}, model reflect.Value, path string) (err error) {
+	// recovering, as a last resort, for any wrong representation in struct
+	defer func() {
+		if r := recover(); r != nil {
+			err = atField(path, fmt.Errorf("data is not a jsonsideload representation of '%v'", model.Type()))
+		}
+	}()
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	if d.opts.maxDepth > 0 && d.depth > d.opts.maxDepth {
+		return atField(path, fmt.Errorf("relations nested deeper than the maximum depth of %d", d.opts.maxDepth))
+	}
+	d.depth++ // counted for leaves as well, the unknown field check telling the root by its depth
+	defer func() { d.depth-- }()
+	if model.Kind() == reflect.Ptr && isLeafType(model.Type().Elem()) { // no relations to walk
+		return d.unMarshalPrimitives(mapToParse, model, path)
+	}
+	if ok, err := unMarshalCustom(mapToParse, model); ok {
+		return atField(path, err)
+	}
+	if model.Kind() != reflect.Ptr || model.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expecting pointer to struct for %s, got %v", path, model.Type())
+	}
+
+	// First, doing a json unmarshal to make sure all primitive types are mapped correct
+	if err := d.unMarshalPrimitives(mapToParse, model, path); err != nil {
+		return err
+	}
+	modelValue := model.Elem()
+	loadedSetter, trackLoaded := model.Interface().(LoadedSetter)
+	var loaded []string
+
+	// Now going through the tagged fields of the struct
+	for _, field := range typePlan(modelValue.Type()) {
+		fieldPath := path + "." + field.name
+		if field.err != nil {
+			return atField(fieldPath, field.err)
+		}
+		fieldValue, ok := fieldByIndex(modelValue, field.index)
+		if !ok {
+			continue
+		}
+
+		if field.referenceField != nil && d.opts.referenceIDs {
+			if err := d.setReferenceIDs(field, mapToParse, modelValue); err != nil {
+				return atField(fieldPath, err)
+			}
+		}
+		if field.condition != "" && !isTruthy(mapToParse[field.condition]) { // not looking the relation up at all
+			continue
+		}
+		if d.opts.skipsRelation(field) {
+			continue
+		}
+		switch field.annotation {
+		case annotationInclude, annotationIncludeFirst, annotationIncludeLast: // the object is already nested, alone or in an array
+			err = d.unMarshalInclude(field, mapToParse, fieldValue, fieldPath)
+		case annotationIncludes: // includes means the array is already nested and not sideloaded
+			err = d.unMarshalIncludes(field, mapToParse, fieldValue, fieldPath)
+		case annotationHasOneRelation: // hasone means the relationship is sideloaded
+			err = d.unMarshalHasOne(field, mapToParse, fieldValue, fieldPath)
+		case annotationHasManyRelation: // hasmany means the relationships are sideloaded
+			err = d.unMarshalHasMany(field, mapToParse, fieldValue, fieldPath)
+		case annotationHasManyReverse: // hasmany_reverse means the sideloaded relationships point back at the node
+			err = d.unMarshalHasManyReverse(field, mapToParse, fieldValue, fieldPath)
+		case annotationFlatten: // flatten means the field is read from a nested object
+			err = d.unMarshalFlatten(field, mapToParse, fieldValue, fieldPath)
+		case annotationRaw: // raw means the nested or sideloaded JSON is kept as is
+			err = d.unMarshalRaw(field, mapToParse, fieldValue, fieldPath)
+		case annotationCount: // count means the field holds the length of an array of ids
+			d.unMarshalCount(field, mapToParse, fieldValue)
+		case annotationRest: // rest means the field holds the keys nothing else reads
+			err = atField(fieldPath, d.unMarshalRest(mapToParse, modelValue, fieldValue))
+		case annotationZip: // zip means the objects are paired from parallel arrays by position
+			err = d.unMarshalZip(field, mapToParse, fieldValue, fieldPath)
+		}
+		if err != nil {
+			return err
+		}
+		if trackLoaded && field.isRelation() && isPopulated(fieldValue) {
+			loaded = append(loaded, field.name)
+		}
+	}
+	if trackLoaded {
+		loadedSetter.SetLoaded(loaded)
+	}
+	return nil
+}
+
+// isPopulated - reports whether a relation field holds a decoded object: a non nil pointer or interface,
+// a non empty slice or map, or a non zero struct
+func isPopulated(fieldValue reflect.Value) bool {
+	switch fieldValue.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return !fieldValue.IsNil()
+	case reflect.Slice, reflect.Map:
+		return fieldValue.Len() > 0
+	}
+	return !fieldValue.IsZero()
+}
+
+// unMarshalPrimitives - decodes the untagged fields of the model from the node.
+// With unknown fields disallowed, keys that are neither struct fields nor read by its relations are an error.
+func (d *decodeState) unMarshalPrimitives(mapToParse map[string]interface{}, model reflect.Value, path string) error {
+	typeErrs, err := decodePrimitives(mapToParse, d.document.keyOrder(mapToParse), model.Elem(), d.opts)
+	if err != nil {
+		return atField(path, err)
+	}
+	for _, typeErr := range typeErrs {
+		if !d.opts.tolerantFields {
+			return atField(path, typeErr)
+		}
+		d.errs = append(d.errs, atField(path, typeErr)) // keeping the object, the field left as it was
+	}
+	if !d.opts.disallowUnknownFields || hasRestField(model.Elem().Type()) {
+		return nil
+	}
+	if d.selfViews[selfView{reflect.ValueOf(mapToParse).Pointer(), model.Elem().Type()}] { // checked by the node it views
+		return nil
+	}
+	if unknown := d.unknownKeys(mapToParse, model.Elem().Type()); len(unknown) > 0 {
+		return fmt.Errorf("unknown field %q in %s", unknown[0], path)
+	}
+	return nil
+}
+
+// unknownKeys - the keys of the node that are neither fields of the struct type nor read by its relations, sorted
+func (d *decodeState) unknownKeys(mapToParse map[string]interface{}, modelType reflect.Type) []string {
+	plan := primitivePlanOf(modelType)
+	relationKeys := d.relationKeys(modelType)
+	var unknown []string
+	for key := range mapToParse {
+		if !relationKeys[key] && !plan.has(key, d.opts.fieldNameMapper) && !d.matchesRelationKey(relationKeys, key) &&
+			!d.readBySelfView(key, modelType, map[reflect.Type]bool{modelType: true}) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// readBySelfView - reports whether a type the struct type decodes the node itself into reads the key
+func (d *decodeState) readBySelfView(key string, modelType reflect.Type, seen map[reflect.Type]bool) bool {
+	for _, field := range typePlan(modelType) {
+		if field.annotation != annotationInclude || field.relation != selfRelation {
+			continue
+		}
+		viewType := structType(modelType.FieldByIndex(field.index).Type)
+		if viewType.Kind() != reflect.Struct || seen[viewType] {
+			continue
+		}
+		seen[viewType] = true
+		if primitivePlanOf(viewType).has(key, d.opts.fieldNameMapper) || d.relationKeys(viewType)[key] ||
+			d.readBySelfView(key, viewType, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// unMarshalRest - sets the map field to the keys of the node that no other field or relation reads
+func (d *decodeState) unMarshalRest(mapToParse map[string]interface{}, modelValue, fieldValue reflect.Value) error {
+	unknown := d.unknownKeys(mapToParse, modelValue.Type())
+	if len(unknown) == 0 {
+		return nil
+	}
+	rest := reflect.MakeMapWithSize(fieldValue.Type(), len(unknown))
+	for _, key := range unknown {
+		value := reflect.New(fieldValue.Type().Elem()).Elem()
+		if err := decodePrimitive(value, primitiveField{name: key}, mapToParse[key]); err != nil {
+			return err
+		}
+		rest.SetMapIndex(reflect.ValueOf(key).Convert(fieldValue.Type().Key()), value)
+	}
+	fieldValue.Set(rest)
+	return nil
+}
+
+// matchesRelationKey - reports whether the key matcher accepts the json key for one of the relation keys
+func (d *decodeState) matchesRelationKey(relationKeys map[string]bool, jsonKey string) bool {
+	if d.opts.keyMatcher == nil {
+		return false
+	}
+	for key := range relationKeys {
+		if d.opts.keyMatcher(key, jsonKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// relationKeys - the keys of the node its relations read, and at the root the sideloaded arrays
+func (d *decodeState) relationKeys(modelType reflect.Type) map[string]bool {
+	relationKeys := make(map[string]bool)
+	for _, field := range typePlan(modelType) {
+		if field.condition != "" {
+			relationKeys[field.condition] = true
+		}
+		switch field.annotation {
+		case annotationInclude, annotationIncludes, annotationIncludeFirst, annotationIncludeLast, annotationFlatten,
+			annotationCount:
+			relationKeys[field.relation] = true
+		case annotationHasOneRelation, annotationHasManyRelation:
+			if len(field.idPath) > 0 {
+				relationKeys[field.idPath[0]] = true
+			}
+			for _, scopeField := range field.scopeFields {
+				relationKeys[scopeField] = true
+			}
+			if parentKey := field.parentKey(d.opts.idField); parentKey != "" {
+				relationKeys[parentKey] = true
+			}
+			if d.opts.inlineRelations && field.annotation == annotationHasOneRelation && field.jsonKey != "" {
+				relationKeys[field.jsonKey] = true
+			}
+			if field.discriminator != "" {
+				relationKeys[field.discriminator] = true
+			}
+		case annotationHasManyReverse:
+			relationKeys[field.parentKey(d.opts.idField)] = true
+		case annotationRaw:
+			if len(field.idPath) > 0 {
+				relationKeys[field.idPath[0]] = true
+			} else {
+				relationKeys[field.relation] = true
+			}
+		case annotationZip:
+			for _, key := range field.zipped {
+				relationKeys[key] = true
+			}
+		}
+	}
+	if d.depth == 1 && d.opts.sideloadRoot != "" {
+		relationKeys[strings.Split(d.opts.sideloadRoot, ".")[0]] = true
+	} else if d.depth == 1 {
+		sideloadedCollections(modelType, make(map[reflect.Type]bool), relationKeys)
+	}
+	return relationKeys
+}
+
+// sideloadedCollections - adds the top level keys of the arrays sideloaded for the struct type and the types it relates to
+func sideloadedCollections(modelType reflect.Type, seen map[reflect.Type]bool, keys map[string]bool) {
+	if modelType.Kind() != reflect.Struct || seen[modelType] {
+		return
+	}
+	seen[modelType] = true
+	for _, field := range typePlan(modelType) {
+		if field.err != nil {
+			continue
+		}
+		if field.annotation == annotationHasOneRelation || field.annotation == annotationHasManyRelation ||
+			field.annotation == annotationHasManyReverse || (field.annotation == annotationRaw && field.idField != "") {
+			keys[field.relation] = true
+			keys[strings.Split(field.relation, ".")[0]] = true
+		}
+		for _, candidate := range field.candidates {
+			keys[candidate] = true
+		}
+		fieldType := modelType.FieldByIndex(field.index).Type
+		if fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array || fieldType.Kind() == reflect.Map {
+			fieldType = fieldType.Elem()
+		}
+		sideloadedCollections(structType(fieldType), seen, keys)
+	}
+}
+
+// setReferenceIDs - copies the relation ids of the node into the reference field of the relation
+func (d *decodeState) setReferenceIDs(field fieldPlan, mapToParse map[string]interface{}, modelValue reflect.Value) error {
+	ids := field.idValue(mapToParse)
+	if ids == nil {
+		return nil
+	}
+	referenceValue, ok := fieldByIndex(modelValue, field.referenceField.index)
+	if !ok {
+		return nil
+	}
+	return decodePrimitive(referenceValue, *field.referenceField, ids)
+}
+
+// unMarshalInclude - decodes the object nested under the relation key
+func (d *decodeState) unMarshalInclude(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	relationObj := d.includedValue(field, mapToParse)
+	if relationObj == nil { // a missing or null object leaves the field unset
+		return nil
+	}
+	relationMap, _ := relationObj.(map[string]interface{})
+	modelType, err := d.modelType(field, fieldValue.Type(), relationMap, fieldPath)
+	if modelType == nil {
+		return err
+	}
+	if field.relation == selfRelation { // the node again, which must not cycle back to a type it is decoded into
+		view := selfView{reflect.ValueOf(mapToParse).Pointer(), modelType}
+		if d.selfViews[view] {
+			return atField(fieldPath, fmt.Errorf("include of the node itself cycles back to %s", typeName(modelType)))
+		}
+		if d.selfViews == nil {
+			d.selfViews = make(map[selfView]bool)
+		}
+		d.selfViews[view] = true
+		defer delete(d.selfViews, view)
+	}
+	m := reflect.New(modelType)
+	if relationMap == nil { // only types decoding themselves take non object values
+		if _, err := unMarshalCustom(relationObj, m); err != nil {
+			return inRelation(field, fieldPath, atField(fieldPath, err))
+		}
+	}
+	if relationMap != nil {
+		if err := d.unMarshalNode(relationMap, m, fieldPath); err != nil {
+			return inRelation(field, fieldPath, err)
+		}
+	}
+	assign(fieldValue, m)
+	return nil
+}
+
+// includedValue - the value nested under the relation key, or for include_first and include_last the first or last
+// element of the array there, nil for empty and missing arrays
+func (d *decodeState) includedValue(field fieldPlan, node map[string]interface{}) interface{} {
+	if field.relation == selfRelation && field.annotation == annotationInclude {
+		return node
+	}
+	value, _ := matchedValue(node, field.relation, d.opts.keyMatcher)
+	if field.annotation == annotationInclude {
+		return value
+	}
+	array, _ := value.([]interface{})
+	switch {
+	case len(array) == 0:
+		return nil
+	case field.annotation == annotationIncludeFirst:
+		return array[0]
+	}
+	return array[len(array)-1]
+}
+
+// unMarshalFlatten - decodes the field from its key in the object nested under the relation key
+func (d *decodeState) unMarshalFlatten(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	relation, _ := matchedValue(mapToParse, field.relation, d.opts.keyMatcher)
+	nested, _ := relation.(map[string]interface{})
+	value, ok := matchedValue(nested, field.flattenField.name, strings.EqualFold)
+	if !ok { // a missing object or key leaves the field unset
+		return nil
+	}
+	return atField(fieldPath, decodeValue(fieldValue, *field.flattenField, value, d.opts))
+}
+
+// unMarshalCount - sets the integer field to the number of ids, or objects, of the array under the relation key,
+// leaving it unset when there is no array
+func (d *decodeState) unMarshalCount(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value) {
+	value, _ := matchedValue(mapToParse, field.relation, d.opts.keyMatcher)
+	ids, ok := d.relationIDs(value)
+	if !ok {
+		return
+	}
+	if fieldValue.Kind() >= reflect.Uint && fieldValue.Kind() <= reflect.Uintptr {
+		fieldValue.SetUint(uint64(len(ids)))
+		return
+	}
+	fieldValue.SetInt(int64(len(ids)))
+}
+
+// unMarshalZip - decodes an object per position of the parallel arrays, holding the element of each array at that
+// position under the array key. A missing or null array is zipped as empty, and the field left unset without any.
+func (d *decodeState) unMarshalZip(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	elements, ok := zippedElements(field, mapToParse, d.opts.keyMatcher)
+	if !ok {
+		return nil
 	}
-	return unMarshalNode(sourceMap, sourceMap, reflect.ValueOf(model), make([]string, 0))
+	if err := d.checkRelationItems(len(elements), fieldPath); err != nil {
+		return err
+	}
+	models := relationSlice(fieldValue.Type(), len(elements))
+	for j, elementMap := range elements {
+		elementPath := indexPath(fieldPath, j)
+		modelType, err := d.modelType(field, fieldValue.Type().Elem(), elementMap, elementPath)
+		if modelType == nil {
+			if err != nil && !d.collect(err) {
+				return err
+			}
+			continue
+		}
+		m := reflect.New(modelType)
+		if err := d.unMarshalNode(elementMap, m, elementPath); err != nil {
+			err = inRelation(field, elementPath, err)
+			if d.collect(err) {
+				continue
+			}
+			return err
+		}
+		models = appendModel(models, m)
+	}
+	return atField(fieldPath, setModels(fieldValue, models))
 }
 
-const (
-	annotationJSONSideload    = "jsonsideload"
-	annotationInclude         = "include"
-	annotationIncludes        = "includes"
-	annotationHasOneRelation  = "hasone"
-	annotationHasManyRelation = "hasmany"
-)
+// zippedElements - an object per position of the parallel arrays of the zip, as long as the longest of them,
+// false when none of them is an array
+func zippedElements(field fieldPlan, mapToParse map[string]interface{},
+	keyMatcher func(tagKey, jsonKey string) bool) ([]map[string]interface{}, bool) {
+	arrays := make([][]interface{}, len(field.zipped))
+	length, found := 0, false
+	for i, key := range field.zipped {
+		value, _ := matchedValue(mapToParse, key, keyMatcher)
+		if array, ok := value.([]interface{}); ok {
+			arrays[i], found = array, true
+		}
+		if len(arrays[i]) > length {
+			length = len(arrays[i])
+		}
+	}
+	if !found {
+		return nil, false
+	}
+	elements := make([]map[string]interface{}, length)
+	for j := range elements {
+		elements[j] = make(map[string]interface{}, len(arrays))
+		for i, array := range arrays {
+			if j < len(array) {
+				elements[j][field.zipped[i]] = array[j]
+			}
+		}
+	}
+	return elements, true
+}
 
-func unMarshalNode(sourceMap, mapToParse map[string]interface{}, model reflect.Value, hierarchy []string) (err error) {
-	// recovering for any wrong representation in struct
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("data is not a jsonsideload representation of '%v'", model.Type())
+// unMarshalRaw - sets the json.RawMessage field to the value nested under the relation key, or to the sideloaded
+// object whose id is held by the id field, and a []json.RawMessage field to each element or sideloaded object. The
+// text is kept as written in the payload, and only encoded from the decoded value when the payload does not hold it.
+func (d *decodeState) unMarshalRaw(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	isArray := fieldValue.Type() != rawMessageType
+	var values []interface{}
+	var texts []json.RawMessage
+	switch {
+	case field.idField == "":
+		key, _ := matchedKey(mapToParse, field.relation, d.opts.keyMatcher)
+		value := mapToParse[key]
+		if value == nil { // a missing or null value leaves the field unset
+			return nil
 		}
-	}()
+		if !isArray {
+			values = []interface{}{value}
+			texts = []json.RawMessage{d.document.memberText(mapToParse, key, -1)}
+			break
+		}
+		array, ok := value.([]interface{})
+		if !ok {
+			return atField(fieldPath, fmt.Errorf("expecting an array for %s, got %T", field.relation, value))
+		}
+		if err := d.checkRelationItems(len(array), fieldPath); err != nil {
+			return err
+		}
+		values = array
+		texts = make([]json.RawMessage, len(array))
+		for j := range array {
+			texts[j] = d.document.memberText(array, "", j)
+		}
+	case isArray:
+		ids, _ := d.relationIDs(field.idValue(mapToParse))
+		if err := d.checkRelationItems(len(ids), fieldPath); err != nil {
+			return err
+		}
+		for j, n := range ids {
+			relationMap, err := d.resolveRaw(field, mapToParse, n, indexPath(fieldPath, j))
+			if err != nil {
+				return err
+			}
+			if relationMap != nil {
+				values = append(values, relationMap)
+				texts = append(texts, d.document.text(relationMap))
+			}
+		}
+	default:
+		relationMap, err := d.resolveRaw(field, mapToParse, field.idValue(mapToParse), fieldPath)
+		if relationMap == nil {
+			return err
+		}
+		values = []interface{}{relationMap}
+		texts = []json.RawMessage{d.document.text(relationMap)}
+	}
 
-	// First, doing a json unmarshal to make sure all primitive types are mapped correct
-	jsonString, err := json.Marshal(mapToParse)
+	raw := make([]json.RawMessage, len(values))
+	for i, value := range values {
+		if raw[i] = texts[i]; raw[i] != nil {
+			continue
+		}
+		// objects of resolvers, stores and preprocessors have no text in the payload
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(value); err != nil {
+			return atField(fieldPath, err)
+		}
+		raw[i] = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	}
+	if isArray {
+		fieldValue.Set(reflect.ValueOf(raw).Convert(fieldValue.Type())) // converting to named slice types
+	} else {
+		fieldValue.Set(reflect.ValueOf(raw[0]))
+	}
+	return nil
+}
+
+// resolveRaw - the sideloaded object of a raw relation with the reference, nil for blank and unresolved ones
+func (d *decodeState) resolveRaw(field fieldPlan, mapToParse map[string]interface{}, reference interface{}, path string) (map[string]interface{}, error) {
+	n := referenceID(reference, field.lookupKeyOr(d.opts.idField))
+	if isBlankID(n) {
+		return nil, nil
+	}
+	lookupKey, id := field.relationLookup(mapToParse, n, d.opts.idField)
+	relationMap, err := d.resolveRelation(field.relation, lookupKey, id, path)
+	if err != nil {
+		return nil, err
+	}
+	d.traceLookup(field.relation, lookupKey, id, relationMap != nil, path)
+	if relationMap == nil {
+		return nil, d.strictError(field, lookupKey, id, path)
+	}
+	return relationMap, nil
+}
+
+// unMarshalIncludes - decodes the array of objects nested under the relation key
+func (d *decodeState) unMarshalIncludes(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	relation, _ := matchedValue(mapToParse, field.relation, d.opts.keyMatcher)
+	relationsArray, ok := relation.([]interface{})
+	if !ok { // a missing or null array leaves the field unset, or empty with default slices
+		if d.opts.defaultSlices && fieldValue.Kind() == reflect.Slice && fieldValue.IsNil() {
+			fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, 0))
+		}
+		return nil
+	}
+	if err := d.checkRelationItems(len(relationsArray), fieldPath); err != nil {
+		return err
+	}
+	models := relationSlice(fieldValue.Type(), len(relationsArray))
+	for j, n := range relationsArray {
+		elementPath := indexPath(fieldPath, j)
+		elementMap, _ := n.(map[string]interface{})
+		modelType, err := d.modelType(field, fieldValue.Type().Elem(), elementMap, elementPath)
+		if modelType == nil {
+			if err != nil && !d.collect(err) {
+				return err
+			}
+			continue
+		}
+		m := reflect.New(modelType)
+		if ok, err := unMarshalCustom(n, m); ok {
+			if err != nil {
+				err = inRelation(field, elementPath, atField(elementPath, err))
+				if d.collect(err) {
+					continue
+				}
+				return err
+			}
+			models = appendModel(models, m)
+			continue
+		}
+		if elementMap == nil { // skipping null and other non object elements
+			continue
+		}
+		if err := d.unMarshalNode(elementMap, m, elementPath); err != nil {
+			err = inRelation(field, elementPath, err)
+			if d.collect(err) {
+				continue
+			}
+			return err
+		}
+		models = appendModel(models, m)
+	}
+	return atField(fieldPath, setModels(fieldValue, models))
+}
+
+// unMarshalHasOne - decodes the sideloaded object whose id is held by the id field
+func (d *decodeState) unMarshalHasOne(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	if field.backReference != "" {
+		return d.unMarshalHasOneReverse(field, mapToParse, fieldValue, fieldPath)
+	}
+	if matcher, ok := d.opts.matchers[field.relation]; ok {
+		return d.unMarshalMatched(field, matcher, mapToParse, fieldValue, fieldPath)
+	}
+	if len(field.candidates) > 0 {
+		relation, err := d.candidateRelation(field, mapToParse, fieldPath)
+		if relation == "" {
+			return err
+		}
+		field.relation = relation
+	}
+	id, err := d.singleID(field.idValue(mapToParse), fieldPath)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(jsonString, model.Interface())
+	// the id may be given as an object reference like {"id": 5, "type": "account"}
+	relationID := referenceID(id, field.lookupKeyOr(d.opts.idField))
+	if isBlankID(relationID) { // no relationship to look up, leaving the field unset unless inlined
+		_, err := d.unMarshalInlined(field, mapToParse, fieldValue, fieldPath)
+		return err
+	}
+	lookupKey, relationID := field.relationLookup(mapToParse, relationID, d.opts.idField)
+	// using the relationID, search the source tree for the relationship
+	relationMap, err := d.resolveRelation(field.relation, lookupKey, relationID, fieldPath)
 	if err != nil {
 		return err
 	}
-	modelValue := model.Elem()
-	modelType := model.Type().Elem()
-
-	var er error
-	// Now going through all the fields of the struct
-	for i := 0; i < modelValue.NumField(); i++ {
-		fieldType := modelType.Field(i)
-		tag := fieldType.Tag.Get(annotationJSONSideload)
-		if tag == "" { // Ignoring the fields which doesn't have 'jsonsideload' tags
-			continue
+	d.traceLookup(field.relation, lookupKey, relationID, relationMap != nil, fieldPath)
+	if relationMap == nil {
+		if inlined, err := d.unMarshalInlined(field, mapToParse, fieldValue, fieldPath); inlined || err != nil {
+			return err
 		}
+		return d.strictError(field, lookupKey, relationID, fieldPath)
+	}
+	if !field.accepts(relationMap) { // filtered out by a where modifier
+		return nil
+	}
+	instanceID, _ := canonicalID(relationID)
+	if d.duplicates[indexKey{field.relation, lookupKey}][instanceID] { // recorded with unique hasone ids only
+		return atField(fieldPath, &DuplicateIDError{Relation: field.relation, LookupKey: lookupKey, ID: relationID})
+	}
 
-		fieldValue := modelValue.Field(i)
-		args := strings.Split(tag, ",")
-		if len(args) < 1 { // Error, if there aren't any realationship with the tag
-			er = errors.New("bad jsonsideload struct tag format")
-			break
+	modelType, err := d.modelType(field, fieldValue.Type(), relationMap, fieldPath)
+	if modelType == nil {
+		return err
+	}
+	key := instanceKey{field.relation, instanceID, reflect.PtrTo(modelType)}
+	m, err := d.unMarshalSideloaded(key, relationMap, fieldPath)
+	if err != nil {
+		return inRelation(field, fieldPath, err)
+	}
+	assign(fieldValue, m)
+	return nil
+}
+
+// unMarshalInlined - decodes the object inlined under the json key of a hasone left without a sideloaded object,
+// with inline relations enabled, reporting whether there was one. One with an id is shared like a sideloaded object.
+func (d *decodeState) unMarshalInlined(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value,
+	fieldPath string) (bool, error) {
+	if !d.opts.inlineRelations || field.jsonKey == "" {
+		return false, nil
+	}
+	value, _ := matchedValue(mapToParse, field.jsonKey, d.opts.keyMatcher)
+	relationMap, ok := value.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	modelType, err := d.modelType(field, fieldValue.Type(), relationMap, fieldPath)
+	if modelType == nil {
+		return err != nil, err
+	}
+	var m reflect.Value
+	if id, ok := canonicalID(relationMap[field.lookupKeyOr(d.opts.idField)]); ok {
+		m, err = d.unMarshalSideloaded(instanceKey{field.relation, id, reflect.PtrTo(modelType)}, relationMap, fieldPath)
+	} else {
+		m = reflect.New(modelType)
+		err = d.unMarshalNode(relationMap, m, fieldPath)
+	}
+	if err != nil {
+		return true, inRelation(field, fieldPath, err)
+	}
+	assign(fieldValue, m)
+	return true, nil
+}
+
+// singleID - the id of a hasone, read from an array by the configured policy
+func (d *decodeState) singleID(value interface{}, fieldPath string) (interface{}, error) {
+	ids, ok := value.([]interface{})
+	switch {
+	case !ok:
+		return value, nil
+	case len(ids) == 0:
+		return nil, nil
+	case d.opts.arrayIDs == FirstArrayID, d.opts.arrayIDs == SingleArrayIDs && len(ids) == 1:
+		return ids[0], nil
+	case d.opts.arrayIDs == SingleArrayIDs:
+		return nil, atField(fieldPath, fmt.Errorf("expecting a single id, got an array of %d", len(ids)))
+	}
+	return nil, atField(fieldPath, errors.New("expecting a single id, got an array"))
+}
+
+// candidateRelation - the collection among the candidates of the field that its discriminator names, empty
+// when the discriminator is missing or names none of them
+func (d *decodeState) candidateRelation(field fieldPlan, mapToParse map[string]interface{}, fieldPath string) (string, error) {
+	value, ok := canonicalID(mapToParse[field.discriminator])
+	if !ok {
+		return "", nil
+	}
+	for _, candidate := range field.candidates {
+		if candidate == value || (d.opts.keyMatcher != nil && d.opts.keyMatcher(candidate, value)) {
+			return candidate, nil
 		}
-		annotation := args[0]
+	}
+	if d.opts.strictRelations {
+		return "", atField(fieldPath, fmt.Errorf("unknown %s %q for relation %s", field.discriminator, value,
+			strings.Join(field.candidates, candidateSeparator)))
+	}
+	return "", nil
+}
+
+// unMarshalHasOneReverse - decodes the single sideloaded object whose back reference points at the node's lookup key
+func (d *decodeState) unMarshalHasOneReverse(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	parentID, ok := canonicalID(mapToParse[field.lookupKey])
+	if !ok { // nothing to point back at, leaving the field unset
+		return nil
+	}
+	matches := field.acceptedOf(d.getBackReferences(field.relation, field.backReference, parentID))
+	d.stats.Resolved += len(matches)
+	d.traceLookup(field.relation, field.backReference, mapToParse[field.lookupKey], len(matches) > 0, fieldPath)
+	switch len(matches) {
+	case 0:
+		return d.strictError(field, field.backReference, mapToParse[field.lookupKey], fieldPath)
+	case 1:
+	default:
+		return fmt.Errorf("%d sideloaded %s with %s %v for %s, expecting one", len(matches), field.relation,
+			field.backReference, mapToParse[field.lookupKey], fieldPath)
+	}
+	relationMap := matches[0]
+	modelType, err := d.modelType(field, fieldValue.Type(), relationMap, fieldPath)
+	if modelType == nil {
+		return err
+	}
+	var m reflect.Value
+	if id, ok := canonicalID(relationMap[d.opts.idField]); ok { // shared with the other relations to the object
+		m, err = d.unMarshalSideloaded(instanceKey{field.relation, id, reflect.PtrTo(modelType)}, relationMap, fieldPath)
+	} else {
+		m = reflect.New(modelType)
+		err = d.unMarshalNode(d.preprocess(field.relation, relationMap), m, fieldPath)
+	}
+	if err != nil {
+		return inRelation(field, fieldPath, err)
+	}
+	assign(fieldValue, m)
+	return nil
+}
 
-		// annotation includes means the object is already nested and not sideloaded
-		if annotation == annotationInclude {
-			if fieldValue.Kind() != reflect.Ptr { // Only pointer types are allowed in struct
-				return fmt.Errorf("expecting pointer type for %s in struct", fieldType.Name)
+// unMarshalHasMany - decodes the sideloaded objects whose ids are held by the id field
+func (d *decodeState) unMarshalHasMany(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	if matcher, ok := d.opts.matchers[field.relation]; ok {
+		return d.unMarshalMatched(field, matcher, mapToParse, fieldValue, fieldPath)
+	}
+	// empty rather than nil even without ids, and sized for them all to append them without growing
+	relationsArray, ok := d.relationIDs(field.idValue(mapToParse))
+	isMap := fieldValue.Kind() == reflect.Map
+	var models reflect.Value
+	if isMap {
+		models = reflect.MakeMapWithSize(fieldValue.Type(), len(relationsArray))
+	} else {
+		models = relationSlice(fieldValue.Type(), len(relationsArray))
+	}
+	if ok {
+		if err := d.checkRelationItems(len(relationsArray), fieldPath); err != nil {
+			return err
+		}
+		var resolved map[string]bool // the ids met so far in the field, with deduplication
+		if d.opts.dedupeRelations {
+			resolved = make(map[string]bool, len(relationsArray))
+		}
+		for j, n := range relationsArray { // range on the array of relationship IDS and get each relationship from the source tree
+			if err := d.ctx.Err(); err != nil {
+				return err
 			}
-			if len(args) < 2 {
-				return fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
+			elementPath := indexPath(fieldPath, j)
+			n = referenceID(n, field.lookupKeyOr(d.opts.idField))
+			if isBlankID(n) { // skipping entries without an id
+				continue
 			}
-			relation := args[1]
-			var relationMap map[string]interface{}
-			relationObj := mapToParse[relation]
-			if relationObj != nil {
-				if mapObj, ok := relationObj.(map[string]interface{}); ok {
-					relationMap = mapObj
+			lookupKey, id := field.relationLookup(mapToParse, n, d.opts.idField)
+			if resolved != nil {
+				if canonical, ok := canonicalID(id); ok {
+					if resolved[canonical] {
+						continue
+					}
+					resolved[canonical] = true
 				}
 			}
-			isRelationshipInParent := IsRelationshipInSlice(fieldType.Name, hierarchy)
-
-			m := reflect.New(fieldValue.Type().Elem())
-			if relationMap != nil && !isRelationshipInParent {
-				hierarchy = append(hierarchy, fieldType.Name)
-				if err := unMarshalNode(sourceMap, relationMap, m, hierarchy); err != nil {
-					er = err
-					break
+			relationMap, err := d.resolveRelation(field.relation, lookupKey, id, elementPath)
+			if err != nil {
+				if d.collect(err) {
+					continue
 				}
+				return err
 			}
-			fieldValue.Set(m)
-		} else if annotation == annotationIncludes { // annotation includes mean, the array is already nested and not sideloaded
-			if len(args) < 2 {
-				return fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
-			}
-			if fieldValue.Type().Elem().Kind() != reflect.Ptr {
-				return fmt.Errorf("expecting array of pointers for %s in struct", fieldType.Name)
-			}
-			isRelationshipInParent := IsRelationshipInSlice(fieldType.Name, hierarchy)
-
-			relation := args[1]
-			models := reflect.New(fieldValue.Type()).Elem()
-			hasManyRelations := mapToParse[relation]
-			if hasManyRelations != nil && !isRelationshipInParent {
-				hierarchy = append(hierarchy, fieldType.Name)
-				if relationsArray, ok := hasManyRelations.([]interface{}); ok {
-					for _, n := range relationsArray {
-						m := reflect.New(fieldValue.Type().Elem().Elem())
-						if err := unMarshalNode(sourceMap, n.(map[string]interface{}), m, hierarchy); err != nil {
-							er = err
-							break
-						}
-						models = reflect.Append(models, m)
-					}
+			d.traceLookup(field.relation, lookupKey, id, relationMap != nil, elementPath)
+			if relationMap == nil {
+				if err := d.strictError(field, lookupKey, id, elementPath); err != nil && !d.collect(err) {
+					return err
+				}
+				continue
+			}
+			if !field.accepts(relationMap) {
+				continue
+			}
+			modelType, err := d.modelType(field, fieldValue.Type().Elem(), relationMap, elementPath)
+			if modelType == nil {
+				if err != nil && !d.collect(err) {
+					return err
 				}
+				continue
 			}
-			fieldValue.Set(models)
-		} else if annotation == annotationHasOneRelation { // hasone means, the relationship is sideloaded
-			if fieldValue.Kind() != reflect.Ptr {
-				return fmt.Errorf("expecting pointer type for %s in struct", fieldType.Name)
-			}
-			if len(args) < 2 {
-				return fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
-			}
-			var relationMap map[string]interface{}
-			relation := args[1]
-			relationID := mapToParse[args[2]]
-			if relationID != nil { // using the relationID, search the source tree for the relationship
-				valueMap := getValueFromSourceJSON(sourceMap, relation, relationID.(float64))
-				if valueMap != nil {
-					relationMap = valueMap.(map[string]interface{})
+			instanceID, _ := canonicalID(id)
+			key := instanceKey{field.relation, instanceID, reflect.PtrTo(modelType)}
+			m, err := d.unMarshalSideloaded(key, relationMap, elementPath)
+			if err != nil {
+				err = inRelation(field, elementPath, err)
+				if d.collect(err) {
+					continue
 				}
+				return err
 			}
-			isRelationshipInParent := IsRelationshipInSlice(fieldType.Name, hierarchy)
+			if isMap {
+				setMapModel(models, key.id, m)
+			} else {
+				models = appendModel(models, m)
+			}
+		}
+	}
+	return atField(fieldPath, setModels(fieldValue, models))
+}
 
-			m := reflect.New(fieldValue.Type().Elem())
-			if relationMap != nil && !isRelationshipInParent {
-				hierarchy = append(hierarchy, fieldType.Name)
-				if err := unMarshalNode(sourceMap, relationMap, m, hierarchy); err != nil {
-					er = err
-					break
+// unMarshalHasManyReverse - decodes the sideloaded objects whose id field points back at the node's lookup key
+func (d *decodeState) unMarshalHasManyReverse(field fieldPlan, mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	models := relationSlice(fieldValue.Type(), 0) // empty rather than nil even without children
+	if parentID, ok := canonicalID(mapToParse[field.lookupKeyOr(d.opts.idField)]); ok {
+		children := field.acceptedOf(d.getBackReferences(field.relation, field.idField, parentID))
+		d.stats.Resolved += len(children)
+		if err := d.checkRelationItems(len(children), fieldPath); err != nil {
+			return err
+		}
+		for j, relationMap := range children {
+			if err := d.ctx.Err(); err != nil {
+				return err
+			}
+			elementPath := indexPath(fieldPath, j)
+			modelType, err := d.modelType(field, fieldValue.Type().Elem(), relationMap, elementPath)
+			if modelType == nil {
+				if err != nil && !d.collect(err) {
+					return err
 				}
+				continue
 			}
-			fieldValue.Set(m)
-		} else if annotation == annotationHasManyRelation { // hasmany means, the relationships is sideloaded
-			if len(args) < 2 {
-				return fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
-			}
-			if fieldValue.Type().Elem().Kind() != reflect.Ptr {
-				return fmt.Errorf("expecting array of pointers for %s in struct", fieldType.Name)
-			}
-			models := reflect.New(fieldValue.Type()).Elem()
-			relation := args[1]
-			isRelationshipInParent := IsRelationshipInSlice(fieldType.Name, hierarchy)
-
-			hasManyRelations := mapToParse[args[2]]
-			if hasManyRelations != nil && !isRelationshipInParent {
-				hierarchy = append(hierarchy, fieldType.Name)
-				if relationsArray, ok := hasManyRelations.([]interface{}); ok {
-					for _, n := range relationsArray { // range on the array of relationship IDS and get each relationship from the source tree
-						m := reflect.New(fieldValue.Type().Elem().Elem())
-						relationMap := getValueFromSourceJSON(sourceMap, relation, n.(float64))
-						if relationMap != nil {
-							if err := unMarshalNode(sourceMap, relationMap.(map[string]interface{}), m, hierarchy); err != nil {
-								er = err
-								break
-							}
-							models = reflect.Append(models, m)
-						}
-					}
+			var m reflect.Value
+			if id, ok := canonicalID(relationMap[d.opts.idField]); ok { // shared with the other relations to the object
+				m, err = d.unMarshalSideloaded(instanceKey{field.relation, id, reflect.PtrTo(modelType)}, relationMap, elementPath)
+			} else {
+				m = reflect.New(modelType)
+				err = d.unMarshalNode(d.preprocess(field.relation, relationMap), m, elementPath)
+			}
+			if err != nil {
+				err = inRelation(field, elementPath, err)
+				if d.collect(err) {
+					continue
 				}
+				return err
+			}
+			models = appendModel(models, m)
+		}
+	}
+	return atField(fieldPath, setModels(fieldValue, models))
+}
+
+// unMarshalMatched - decodes the sideloaded objects of the relation the matcher accepts for the node instead of
+// looking ids up, the first of them for a hasone and all of them in collection order for a hasmany
+func (d *decodeState) unMarshalMatched(field fieldPlan, matcher func(parent, candidate map[string]interface{}) bool,
+	mapToParse map[string]interface{}, fieldValue reflect.Value, fieldPath string) error {
+	isSingle := field.annotation == annotationHasOneRelation
+	isMap := fieldValue.Kind() == reflect.Map
+	var matches []map[string]interface{}
+	values, _ := sourceArray(d.sourceMap, field.relation, d.opts.keyMatcher)
+	for _, value := range values {
+		if candidate, ok := value.(map[string]interface{}); ok && field.accepts(candidate) && matcher(mapToParse, candidate) {
+			matches = append(matches, candidate)
+			if isSingle {
+				break
+			}
+		}
+	}
+	d.stats.Resolved += len(matches)
+	if !isSingle {
+		if err := d.checkRelationItems(len(matches), fieldPath); err != nil {
+			return err
+		}
+	}
+
+	var models reflect.Value
+	switch {
+	case isMap:
+		models = reflect.MakeMap(fieldValue.Type())
+	case !isSingle:
+		models = relationSlice(fieldValue.Type(), len(matches))
+	}
+	for j, relationMap := range matches {
+		elementPath, target := fieldPath, fieldValue.Type()
+		if !isSingle {
+			elementPath, target = indexPath(fieldPath, j), target.Elem()
+		}
+		modelType, err := d.modelType(field, target, relationMap, elementPath)
+		if modelType == nil {
+			if err != nil && !d.collect(err) {
+				return err
+			}
+			continue
+		}
+		id, hasID := canonicalID(relationMap[d.opts.idField])
+		var m reflect.Value
+		if hasID { // shared with the other relations to the object
+			m, err = d.unMarshalSideloaded(instanceKey{field.relation, id, reflect.PtrTo(modelType)}, relationMap, elementPath)
+		} else {
+			m = reflect.New(modelType)
+			err = d.unMarshalNode(d.preprocess(field.relation, relationMap), m, elementPath)
+		}
+		if err != nil {
+			err = inRelation(field, elementPath, err)
+			if !isSingle && d.collect(err) {
+				continue
 			}
-			fieldValue.Set(models)
+			return err
+		}
+		switch {
+		case isSingle:
+			assign(fieldValue, m)
+			return nil
+		case isMap && hasID:
+			setMapModel(models, id, m)
+		case !isMap:
+			models = appendModel(models, m)
 		}
 	}
-	return er
+	if !isSingle {
+		return atField(fieldPath, setModels(fieldValue, models))
+	}
+	return nil
 }
 
-// getValueFromSourceJSON - get the sideloaded value from the sourceJSON
-func getValueFromSourceJSON(sourceJSON map[string]interface{}, key string, id float64) interface{} {
-	valFromSourceJSON := sourceJSON[key]
-	if valFromSourceJSON != nil {
-		if valueArray, ok := sourceJSON[key].([]interface{}); ok {
-			for _, v := range valueArray {
-				if valueMap, ok := v.(map[string]interface{}); ok && valueMap["id"] == id {
-					return v
+// getBackReferences - the sideloaded values of key whose backReference matches the id, in collection order
+func (d *decodeState) getBackReferences(key, backReference string, id string) []map[string]interface{} {
+	index, ok := d.backReferences[indexKey{key, backReference}]
+	if !ok {
+		index = make(map[string][]map[string]interface{})
+		values, _ := sourceArray(d.sourceMap, key, d.opts.keyMatcher)
+		for _, v := range values {
+			if valueMap, ok := v.(map[string]interface{}); ok {
+				if parentID, ok := canonicalID(valueMap[backReference]); ok {
+					index[parentID] = append(index[parentID], valueMap)
 				}
 			}
 		}
+		d.backReferences[indexKey{key, backReference}] = index
+	}
+	return index[id]
+}
+
+// checkRelationItems - fails a relation with more elements than the configured maximum
+func (d *decodeState) checkRelationItems(n int, path string) error {
+	if d.opts.maxRelationItems > 0 && n > d.opts.maxRelationItems {
+		return atField(path, fmt.Errorf("%d relation items, more than the maximum of %d", n, d.opts.maxRelationItems))
 	}
 	return nil
 }
+
+// relationIDs - the array of references of a hasmany relation, split from a comma separated string if enabled
+func (d *decodeState) relationIDs(value interface{}) ([]interface{}, bool) {
+	if s, ok := value.(string); ok && d.opts.commaSeparatedIDs {
+		var ids []interface{}
+		for _, id := range strings.Split(s, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids, true
+	}
+	ids, ok := value.([]interface{})
+	return ids, ok
+}
+
+// modelType - the struct type a relation object is decoded into for a field of type target, picked by the
+// discriminator of the relation's polymorphic types for interface fields. Nil when the object is skipped.
+func (d *decodeState) modelType(field fieldPlan, target reflect.Type, relationMap map[string]interface{}, path string) (reflect.Type, error) {
+	if target.Kind() != reflect.Interface {
+		return structType(target), nil
+	}
+	types, ok := d.opts.polymorphic[field.relation]
+	if !ok {
+		return nil, atField(path, fmt.Errorf("no polymorphic types registered for relation %s", field.relation))
+	}
+	if relationMap == nil {
+		return nil, nil
+	}
+	discriminator, _ := canonicalID(relationMap[types.discriminatorField])
+	modelType, ok := types.registry[discriminator]
+	if !ok {
+		if d.opts.strictRelations {
+			return nil, atField(path, fmt.Errorf("unknown %s %q for relation %s", types.discriminatorField, discriminator, field.relation))
+		}
+		return nil, nil
+	}
+	modelType = structType(modelType)
+	if !reflect.PtrTo(modelType).AssignableTo(target) {
+		return nil, atField(path, fmt.Errorf("%v is not assignable to %v", reflect.PtrTo(modelType), target))
+	}
+	return modelType, nil
+}
+
+// unMarshalCustom - hands the raw JSON of value to model if it implements json.Unmarshaler, reporting whether it does
+func unMarshalCustom(value interface{}, model reflect.Value) (bool, error) {
+	unmarshaler, ok := model.Interface().(json.Unmarshaler)
+	if !ok {
+		return false, nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return true, err
+	}
+	return true, unmarshaler.UnmarshalJSON(raw)
+}
+
+// unMarshalSideloaded - decodes a sideloaded object once per call, returning the same instance on every reference.
+// The instance is registered before its relations are walked, so references cycling back to it end there.
+func (d *decodeState) unMarshalSideloaded(key instanceKey, relationMap map[string]interface{}, path string) (reflect.Value, error) {
+	if m, ok := d.instances[key]; ok {
+		d.stats.CacheHits++
+		return m, nil
+	}
+	m := reflect.New(key.modelType.Elem())
+	d.instances[key] = m
+	if err := d.unMarshalNode(d.preprocess(key.relation, relationMap), m, path); err != nil {
+		delete(d.instances, key) // not shared half decoded when decoding goes on past the error
+		return m, err
+	}
+	return m, nil
+}
+
+// preprocess - the sideloaded object of the relation as its preprocessor returns it, the object itself without one
+func (d *decodeState) preprocess(relation string, relationMap map[string]interface{}) map[string]interface{} {
+	preprocessor, ok := d.opts.preprocessors[relation]
+	if !ok {
+		return relationMap
+	}
+	if preprocessed := preprocessor(relationMap); preprocessed != nil {
+		return preprocessed
+	}
+	return relationMap
+}
+
+// inRelation - the error decoding an object of the relation at path as a *RelationError, unless it already is one
+// for a relation nested deeper. Context errors are returned as is.
+func inRelation(field fieldPlan, path string, err error) error {
+	var relationErr *RelationError
+	if err == nil || errors.As(err, &relationErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &RelationError{Relation: field.relation, Field: path, Err: err}
+}
+
+// strictError - the error for an id without a sideloaded object, nil unless relations are strict
+func (d *decodeState) strictError(field fieldPlan, lookupKey string, id interface{}, path string) error {
+	if !d.opts.strictRelations {
+		return nil
+	}
+	return &UnresolvedRelationError{Relation: field.relation, LookupKey: lookupKey, ID: id, Field: path}
+}
+
+// collect - keeps the error of a relation element with WithContinueOnError, reporting whether decoding goes on
+// without the element. Context errors always stop decoding.
+func (d *decodeState) collect(err error) bool {
+	if !d.opts.continueOnError || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	d.errs = append(d.errs, err)
+	return true
+}
+
+// result - the error of the decode, joining the errors kept along the way
+func (d *decodeState) result(err error) error {
+	if len(d.errs) == 0 {
+		return err
+	}
+	return errors.Join(append(d.errs, err)...)
+}
+
+// resolveRelation - the sideloaded object of the relation with the id, falling back to the relation's resolver
+// for ids the document does not sideload. Resolved objects are kept for later lookups of the same id.
+func (d *decodeState) resolveRelation(relation, lookupKey string, id interface{}, path string) (map[string]interface{}, error) {
+	relationMap, err := d.lookupRelation(relation, lookupKey, id, path)
+	if err == nil && relationMap != nil {
+		d.stats.Resolved++
+	} else if err == nil {
+		d.stats.Misses++
+	}
+	return relationMap, err
+}
+
+// lookupRelation - the sideloaded or resolved object of the relation with the id, see resolveRelation
+func (d *decodeState) lookupRelation(relation, lookupKey string, id interface{}, path string) (map[string]interface{}, error) {
+	if relationMap, err := d.getValueFromSourceJSON(relation, lookupKey, id); relationMap != nil || err != nil {
+		return relationMap, err
+	}
+	resolver, ok := d.opts.resolvers[relation]
+	canonical, isScalar := canonicalID(id)
+	if !ok || !isScalar {
+		return nil, nil
+	}
+	relationMap, err := resolver(id)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s %v for %s: %w", relation, id, path, err)
+	}
+	if relationMap != nil {
+		d.indexes[indexKey{relation, lookupKey}][canonical] = relationMap
+	}
+	return relationMap, nil
+}
+
+// getValueFromSourceJSON - get the sideloaded value from the sourceJSON whose lookupKey matches the id,
+// numbers matching their string form
+func (d *decodeState) getValueFromSourceJSON(key, lookupKey string, id interface{}) (map[string]interface{}, error) {
+	canonical, ok := canonicalID(id)
+	if !ok {
+		return nil, nil
+	}
+	if err := d.indexErrs[indexKey{key, lookupKey}]; err != nil {
+		return nil, err
+	}
+	index, ok := d.indexes[indexKey{key, lookupKey}]
+	if !ok {
+		var duplicates []interface{}
+		values, keys := sourceArray(d.sourceMap, key, d.opts.keyMatcher)
+		if lookupKey != d.opts.idField {
+			keys = nil // the keys of a collection object are ids, not the other keys objects are looked up by
+		}
+		index, duplicates = indexSourceJSON(values, keys, lookupKey)
+		if d.opts.stores != nil { // the ids the document leaves out are looked up in the stores
+			d.indexStores(index, key, lookupKey)
+		}
+		d.indexes[indexKey{key, lookupKey}] = index
+		for _, duplicate := range duplicates {
+			if d.opts.uniqueHasOne {
+				d.addDuplicate(indexKey{key, lookupKey}, duplicate)
+			}
+			if d.opts.logger != nil {
+				d.opts.logger(EventDuplicateID, map[string]interface{}{"relation": key, "lookup_key": lookupKey, "id": duplicate})
+			}
+			if d.opts.uniqueIDs {
+				// kept with the index, so that every lookup of the relation fails rather than only the first
+				err := &DuplicateIDError{Relation: key, LookupKey: lookupKey, ID: duplicate}
+				if d.indexErrs == nil {
+					d.indexErrs = make(map[indexKey]error)
+				}
+				d.indexErrs[indexKey{key, lookupKey}] = err
+				return nil, err
+			}
+		}
+	}
+	return index[canonical], nil
+}
+
+// addDuplicate - records the id as shared by several sideloaded objects of the index
+func (d *decodeState) addDuplicate(key indexKey, id interface{}) {
+	canonical, _ := canonicalID(id)
+	if d.duplicates == nil {
+		d.duplicates = make(map[indexKey]map[string]bool)
+	}
+	if d.duplicates[key] == nil {
+		d.duplicates[key] = make(map[string]bool)
+	}
+	d.duplicates[key][canonical] = true
+}
+
+// indexStores - adds the objects of the collection in the stores to the index, for the ids it does not hold
+func (d *decodeState) indexStores(index map[string]map[string]interface{}, key, lookupKey string) {
+	values, keys := sourceArray(d.opts.stores, key, d.opts.keyMatcher)
+	if lookupKey != d.opts.idField {
+		keys = nil
+	}
+	stored, _ := indexSourceJSON(values, keys, lookupKey)
+	for id, valueMap := range stored {
+		if _, exists := index[id]; !exists {
+			index[id] = valueMap
+		}
+	}
+}
+
+// traceLookup - reports a relation lookup to the logger, if one is configured
+func (d *decodeState) traceLookup(relation, lookupKey string, id interface{}, found bool, path string) {
+	if d.opts.logger == nil {
+		return
+	}
+	d.opts.logger(EventRelationLookup, map[string]interface{}{
+		"relation":   relation,
+		"lookup_key": lookupKey,
+		"id":         id,
+		"found":      found,
+		"field":      path,
+	})
+}
+
+// indexSourceJSON - maps the ids of the sideloaded values to the values, the first value winning on duplicates.
+// Values without a lookupKey of their own are indexed by their key in keys, if given. The ids of the values losing
+// out are returned as well.
+func indexSourceJSON(values []interface{}, keys []string, lookupKey string) (map[string]map[string]interface{}, []interface{}) {
+	var duplicates []interface{}
+	index := make(map[string]map[string]interface{})
+	for i, v := range values {
+		valueMap, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rawID := lookupID(valueMap, lookupKey)
+		if rawID == nil && keys != nil {
+			rawID = keys[i]
+		}
+		if id, ok := canonicalID(rawID); ok {
+			if _, exists := index[id]; exists {
+				duplicates = append(duplicates, rawID)
+				continue
+			}
+			index[id] = valueMap
+		}
+	}
+	return index, duplicates
+}
+
+// sourceArray - the values of the sideloaded collection under key. A collection given as an object keyed by id,
+// as normalized stores hold them, gives its values in key order along with the keys.
+func sourceArray(sourceJSON map[string]interface{}, key string, matcher func(tagKey, jsonKey string) bool) ([]interface{}, []string) {
+	switch collection := sourceCollection(sourceJSON, key, matcher).(type) {
+	case []interface{}:
+		return collection, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(collection))
+		for id := range collection {
+			keys = append(keys, id)
+		}
+		sort.Strings(keys)
+		values := make([]interface{}, len(keys))
+		for i, id := range keys {
+			values[i] = collection[id]
+		}
+		return values, keys
+	}
+	return nil, nil
+}
+
+// sourceCollection - the sideloaded collection under key, a dotted key reaching into nested objects
+// unless the sourceJSON holds it as is, and then a top level key the matcher accepts
+func sourceCollection(sourceJSON map[string]interface{}, key string, matcher func(tagKey, jsonKey string) bool) interface{} {
+	if collection, ok := sourceJSON[key]; ok {
+		return collection
+	}
+	if strings.Contains(key, ".") {
+		if collection := valueAtPath(sourceJSON, strings.Split(key, ".")); collection != nil {
+			return collection
+		}
+	}
+	collection, _ := matchedValue(sourceJSON, key, matcher)
+	return collection
+}
+
+// matchedValue - the value under key in the node, or else under the first key in sorted order the matcher accepts
+func matchedValue(node map[string]interface{}, key string, matcher func(tagKey, jsonKey string) bool) (interface{}, bool) {
+	if value, ok := node[key]; ok || matcher == nil {
+		return value, ok
+	}
+	jsonKey, ok := matchedKey(node, key, matcher)
+	return node[jsonKey], ok
+}
+
+// matchedKey - the key of the node matchedValue reads, false when none matches
+func matchedKey(node map[string]interface{}, key string, matcher func(tagKey, jsonKey string) bool) (string, bool) {
+	if _, ok := node[key]; ok || matcher == nil {
+		return key, ok
+	}
+	keys := make([]string, 0, len(node))
+	for jsonKey := range node {
+		if matcher(key, jsonKey) {
+			keys = append(keys, jsonKey)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	return keys[0], true
+}
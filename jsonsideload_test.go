@@ -1,10 +1,19 @@
 package jsonsideload
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,6 +22,21 @@ func prepareTestData() ([]byte, error) {
 	return ioutil.ReadFile("test.json")
 }
 
+// prepareLargeTestData - a person who lived in each of n sideloaded cities
+func prepareLargeTestData(n int) []byte {
+	ids := make([]int, n)
+	cities := make([]map[string]interface{}, n)
+	for i := range ids {
+		ids[i] = i + 1
+		cities[i] = map[string]interface{}{"id": i + 1, "name": fmt.Sprintf("city %d", i+1)}
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"persons": []interface{}{map[string]interface{}{"id": 1, "name": "Vignesh", "current_city_id": n, "lived_city_ids": ids}},
+		"cities":  cities,
+	})
+	return data
+}
+
 func TestUnmarshal(t *testing.T) {
 	data, err := prepareTestData()
 	if err != nil {
@@ -34,6 +58,1288 @@ func TestUnmarshal(t *testing.T) {
 	fmt.Println(string(resp))
 }
 
+func TestUnmarshalStringIDs(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "plan": "gold", "account_id": "u_123", "manager_ids": ["u_456", "u_123"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Subscriptions, 1)
+	sub := resp.Subscriptions[0]
+	assert.Equal(t, "Acme", sub.Account.Name)
+	assert.Len(t, sub.Managers, 2)
+	assert.Equal(t, "Globex", sub.Managers[0].Name)
+	assert.Equal(t, "Acme", sub.Managers[1].Name)
+}
+
+func TestUnmarshalCustomLookupKey(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"name": "router",
+		"owner_uuid": "b7e1",
+		"owner_uuids": ["a3f2", "b7e1"],
+		"owners": [{"name": "no key"}, {"uuid": "a3f2", "name": "Ann"}, {"uuid": "b7e1", "name": "Bob"}]
+	}`)
+	device := new(Device)
+	err := Unmarshal(data, device)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bob", device.Owner.Name)
+	assert.Len(t, device.Owners, 2)
+	assert.Equal(t, "Ann", device.Owners[0].Name)
+	assert.Equal(t, "Bob", device.Owners[1].Name)
+}
+
+func TestUnmarshalMissingIDFieldArgument(t *testing.T) {
+	data := []byte(`{"accounts": [{"id": "u_123"}]}`)
+	err := Unmarshal(data, new(MissingHasOneIDField))
+	assert.EqualError(t, err, "MissingHasOneIDField.Account: hasone relation Account requires an id field argument")
+	err = Unmarshal(data, new(MissingHasManyIDField))
+	assert.EqualError(t, err, "MissingHasManyIDField.Accounts: hasmany relation Accounts requires an id field argument")
+}
+
+func TestUnmarshalCircularRelations(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"customer_id": 7,
+		"orders": [{"id": 1, "customer_id": 7}, {"id": 2, "customer_id": 7}],
+		"customers": [{"id": 7, "name": "Ada", "order_ids": [1, 2]}]
+	}`)
+	order := new(Order)
+	err := Unmarshal(data, order)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ada", order.Customer.Name)
+	assert.Len(t, order.Customer.Orders, 2)
+	// orders pointing back to customer 7 share the instance being decoded
+	assert.True(t, order.Customer.Orders[0].Customer == order.Customer)
+	assert.True(t, order.Customer.Orders[1].Customer == order.Customer)
+}
+
+func TestUnmarshalSharedRelations(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [
+			{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_123"]},
+			{"id": "s_2", "account_id": "u_123"}
+		],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp)
+	assert.Nil(t, err)
+	first, second := resp.Subscriptions[0], resp.Subscriptions[1]
+	assert.Equal(t, "Acme", first.Account.Name)
+	assert.True(t, first.Account == second.Account)
+	assert.True(t, first.Account == first.Managers[0])
+}
+
+func TestUnmarshalLargeIntegerIDs(t *testing.T) {
+	// both ids round to the same float64
+	data := []byte(`{
+		"id": 1234567890123456000,
+		"author_id": 1234567890123456789,
+		"authors": [{"id": 1234567890123456788, "name": "Ann"}, {"id": 1234567890123456789, "name": "Bob"}]
+	}`)
+	post := new(Post)
+	err := Unmarshal(data, post)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1234567890123456789), post.Author.ID)
+	assert.Equal(t, "Bob", post.Author.Name)
+}
+
+func TestUnmarshalCustomUnmarshaler(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"total": "12.50 USD",
+		"payments": ["10.00 USD", {"amount": "2.50 USD"}],
+		"refund_id": 3,
+		"refunds": [{"id": 3, "amount": "1.25 EUR"}]
+	}`)
+	invoice := new(Invoice)
+	err := Unmarshal(data, invoice)
+	assert.Nil(t, err)
+	assert.Equal(t, &Money{Cents: 1250, Currency: "USD"}, invoice.Total)
+	assert.Equal(t, []*Money{{Cents: 1000, Currency: "USD"}, {Cents: 250, Currency: "USD"}}, invoice.Payments)
+	assert.Equal(t, &Money{Cents: 125, Currency: "EUR"}, invoice.Refund)
+}
+
+func TestUnmarshalHasManyReferenceObjects(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "manager_ids": [{"id": "u_456"}, "u_123", {"name": "no id"}, null]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp, WithStrictRelations(true))
+	assert.Nil(t, err)
+	managers := resp.Subscriptions[0].Managers
+	assert.Len(t, managers, 2)
+	assert.Equal(t, "Globex", managers[0].Name)
+	assert.Equal(t, "Acme", managers[1].Name)
+}
+
+func TestUnmarshalTopLevelArray(t *testing.T) {
+	data := []byte(`[
+		{"id": 1, "customer_id": 7, "customers": [{"id": 7, "name": "Ada"}]},
+		{"id": 2, "customer_id": 8, "customers": [{"id": 8, "name": "Grace"}]}
+	]`)
+	var orders []*Order
+	err := Unmarshal(data, &orders)
+	assert.Nil(t, err)
+	assert.Len(t, orders, 2)
+	assert.Equal(t, "Ada", orders[0].Customer.Name)
+	assert.Equal(t, "Grace", orders[1].Customer.Name)
+
+	err = Unmarshal(data, new(Order))
+	assert.NotNil(t, err)
+	err = Unmarshal([]byte(`[1, 2]`), &orders)
+	assert.EqualError(t, err, "expecting an object at index 0 of the top level array")
+
+	// models the array cannot be set through fail rather than panic
+	err = Unmarshal([]byte(`[{}]`), nil)
+	assert.EqualError(t, err, "expecting pointer to a slice of pointers for a top level array, got <nil>")
+	err = Unmarshal([]byte(`[{}]`), (*[]*Order)(nil))
+	assert.EqualError(t, err, "expecting pointer to a slice of pointers for a top level array, got *[]*jsonsideload.Order")
+	err = Unmarshal([]byte(`[{}]`), orders)
+	assert.EqualError(t, err, "expecting pointer to a slice of pointers for a top level array, got []*jsonsideload.Order")
+}
+
+func TestUnmarshalContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := UnmarshalContext(ctx, prepareLargeTestData(100), new(PersonResponse))
+	assert.Equal(t, context.Canceled, err)
+
+	err = UnmarshalContext(context.Background(), prepareLargeTestData(100), new(PersonResponse))
+	assert.Nil(t, err)
+}
+
+func TestUnmarshalConcurrent(t *testing.T) {
+	data, err := prepareTestData()
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			personResp := new(PersonResponse)
+			assert.Nil(t, Unmarshal(data, personResp))
+			assert.Equal(t, "Chennai", personResp.Persons[0].CurrentCity.Name)
+		}()
+	}
+	wg.Wait()
+	_, cached := typePlans.Load(reflect.TypeOf(Person{}))
+	assert.True(t, cached)
+}
+
+func TestUnmarshalValueSlices(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"tags": [{"name": "go"}, {"name": "json"}],
+		"related": [{"name": "rails"}],
+		"keyword_ids": [2, 1],
+		"keywords": [{"id": 1, "value": "sideload"}, {"id": 2, "value": "deserialize"}]
+	}`)
+	article := new(Article)
+	err := Unmarshal(data, article)
+	assert.Nil(t, err)
+	assert.Equal(t, []Tag{{Name: "go"}, {Name: "json"}}, article.Tags)
+	assert.Equal(t, []*Tag{{Name: "rails"}}, article.Related)
+	assert.Equal(t, []Keyword{{ID: 2, Value: "deserialize"}, {ID: 1, Value: "sideload"}}, article.Keywords)
+
+	out, err := Marshal(article)
+	assert.Nil(t, err)
+	roundTripped := new(Article)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, article, roundTripped)
+}
+
+func TestUnmarshalValueStructs(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"address": {"street": "1 Infinite Loop", "city": "Cupertino"},
+		"backup_route": {"hops": 3},
+		"carrier_id": 5,
+		"carriers": [{"id": 5, "name": "UPS"}]
+	}`)
+	shipment := new(Shipment)
+	err := Unmarshal(data, shipment)
+	assert.Nil(t, err)
+	assert.Equal(t, Address{Street: "1 Infinite Loop", City: "Cupertino"}, shipment.Address)
+	assert.Equal(t, Carrier{ID: 5, Name: "UPS"}, shipment.Carrier)
+	assert.Equal(t, &Route{Hops: 3}, shipment.BackupRoute)
+
+	out, err := Marshal(shipment)
+	assert.Nil(t, err)
+	roundTripped := new(Shipment)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, shipment, roundTripped)
+}
+
+func TestUnmarshalMalformedJSON(t *testing.T) {
+	err := Unmarshal([]byte(`{"persons": [}`), new(PersonResponse))
+	assert.Contains(t, err.Error(), "malformed JSON provided: ")
+	var syntaxErr *json.SyntaxError
+	if assert.True(t, errors.As(err, &syntaxErr)) {
+		assert.Equal(t, int64(14), syntaxErr.Offset)
+	}
+
+	err = Unmarshal([]byte(`"persons"`), new(PersonResponse))
+	assert.EqualError(t, err, "malformed JSON provided: expecting an object or an array, got string")
+}
+
+func TestUnmarshalWrongShapes(t *testing.T) {
+	err := Unmarshal([]byte(`{"count": {"value": 1}}`), new(BadInclude))
+	assert.EqualError(t, err, "expecting pointer to struct for BadInclude.Count, got *int")
+}
+
+func TestUnmarshalNestedIDField(t *testing.T) {
+	accounts := `"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]`
+	ticket := new(Ticket)
+	err := Unmarshal([]byte(`{"id": 1, "links": {"account": "u_456", "watchers": ["u_123"]}, `+accounts+`}`), ticket)
+	assert.Nil(t, err)
+	assert.Equal(t, "Globex", ticket.Account.Name)
+	assert.Equal(t, []Account{{ID: "u_123", Name: "Acme"}}, ticket.Watchers)
+
+	for _, data := range []string{`{"id": 1, ` + accounts + `}`, `{"id": 1, "links": "none", ` + accounts + `}`} {
+		ticket = new(Ticket)
+		err = Unmarshal([]byte(data), ticket, WithStrictRelations(true))
+		assert.Nil(t, err)
+		assert.Nil(t, ticket.Account)
+		assert.Empty(t, ticket.Watchers)
+	}
+}
+
+func TestUnmarshalSelfReferencingTree(t *testing.T) {
+	data := []byte(`{
+		"root_id": 1,
+		"categories": [
+			{"id": 1, "name": "root", "children_ids": [2, 3]},
+			{"id": 2, "name": "books", "parent_id": 1, "children_ids": [4]},
+			{"id": 3, "name": "music", "parent_id": 1, "children_ids": []},
+			{"id": 4, "name": "poetry", "parent_id": 2}
+		]
+	}`)
+	resp := new(CategoryResponse)
+	err := Unmarshal(data, resp)
+	assert.Nil(t, err)
+	root := resp.Root
+	assert.Equal(t, "root", root.Name)
+	assert.Len(t, root.Children, 2)
+	books, music := root.Children[0], root.Children[1]
+	assert.Equal(t, "books", books.Name)
+	assert.Equal(t, "music", music.Name)
+	assert.True(t, books.Parent == root)
+	assert.True(t, music.Parent == root)
+	assert.Len(t, books.Children, 1)
+	poetry := books.Children[0]
+	assert.Equal(t, "poetry", poetry.Name)
+	assert.True(t, poetry.Parent == books)
+	assert.Empty(t, poetry.Children)
+}
+
+func TestUnmarshalEmptyRelations(t *testing.T) {
+	accounts := `"accounts": [{"id": 0, "name": "zero"}, {"id": "", "name": "blank"}]`
+	cases := []struct {
+		name string
+		data string
+		// photos is nil for a missing array and empty for an empty one
+		photos []*Photo
+	}{
+		{"missing", `{` + accounts + `}`, nil},
+		{"null", `{"avatar": null, "photos": null, "account_id": null, "follower_ids": null, ` + accounts + `}`, nil},
+		{"empty", `{"photos": [], "follower_ids": [], ` + accounts + `}`, []*Photo{}},
+		{"zero ids", `{"account_id": 0, "follower_ids": [0, "", null], ` + accounts + `}`, nil},
+		{"blank id", `{"account_id": "", ` + accounts + `}`, nil},
+	}
+	for _, c := range cases {
+		profile := new(Profile)
+		err := Unmarshal([]byte(c.data), profile, WithStrictRelations(true))
+		assert.Nil(t, err, c.name)
+		assert.Nil(t, profile.Avatar, c.name)
+		assert.Equal(t, c.photos, profile.Photos, c.name)
+		assert.Nil(t, profile.Account, c.name)
+		// hasmany is always a non nil slice
+		assert.Equal(t, []*Account{}, profile.Followers, c.name)
+	}
+}
+
+func TestUnmarshalNestedCollection(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"author_id": "u_123",
+		"liker_ids": ["u_456"],
+		"flagger_id": "u_789",
+		"included": {"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]},
+		"accounts": [{"id": "u_789", "name": "Initech"}]
+	}`)
+	comment := new(Comment)
+	err := Unmarshal(data, comment)
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", comment.Author.Name)
+	assert.Equal(t, "Globex", comment.Likers[0].Name)
+	assert.Equal(t, "Initech", comment.Flagger.Name)
+
+	out, err := Marshal(comment)
+	assert.Nil(t, err)
+	roundTripped := new(Comment)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, comment, roundTripped)
+}
+
+func TestUnmarshalHasManyMap(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"member_ids": ["u_123", "u_456", "u_missing"],
+		"sponsor_ids": ["u_456"],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	team := new(Team)
+	err := Unmarshal(data, team)
+	assert.Nil(t, err)
+	assert.Len(t, team.Members, 2)
+	assert.Equal(t, "Acme", team.Members["u_123"].Name)
+	assert.Equal(t, "Globex", team.Members["u_456"].Name)
+	assert.NotContains(t, team.Members, "u_missing")
+	assert.Equal(t, Account{ID: "u_456", Name: "Globex"}, team.Sponsors["u_456"])
+
+	out, err := Marshal(team)
+	assert.Nil(t, err)
+	roundTripped := new(Team)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, team, roundTripped)
+
+	empty := new(Team)
+	assert.Nil(t, Unmarshal([]byte(`{"id": 2}`), empty))
+	assert.NotNil(t, empty.Members)
+	assert.Empty(t, empty.Members)
+
+	err = Unmarshal([]byte(`{"member_ids": [1]}`), new(BadMapKey))
+	assert.EqualError(t, err, "BadMapKey.Members: expecting map of pointers keyed by string for Members in struct")
+}
+
+func TestUnmarshalCompoundKey(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"tenant_id": "t_2",
+		"account_id": "u_1",
+		"admin_ids": ["u_1", "u_2"],
+		"owner_uuid": "a3f2",
+		"accounts": [
+			{"id": "u_1", "uuid": "a3f2", "tenant_id": "t_1", "name": "Acme"},
+			{"id": "u_1", "uuid": "a3f2", "tenant_id": "t_2", "name": "Globex"},
+			{"id": "u_2", "uuid": "b7c1", "tenant_id": "t_2", "name": "Initech"}
+		]
+	}`)
+	membership := new(Membership)
+	err := Unmarshal(data, membership)
+	assert.Nil(t, err)
+	assert.Equal(t, "Globex", membership.Account.Name)
+	assert.Len(t, membership.Admins, 2)
+	assert.Equal(t, membership.Account, membership.Admins[0])
+	assert.Equal(t, "Initech", membership.Admins[1].Name)
+	assert.Equal(t, "Globex", membership.Owner.Name)
+
+	out, err := Marshal(membership)
+	assert.Nil(t, err)
+	roundTripped := new(Membership)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, membership, roundTripped)
+
+	err = Unmarshal([]byte(`{"tenant_id": "t_3", "account_id": "u_1", "accounts": []}`), new(Membership), WithStrictRelations(true))
+	assert.EqualError(t, err, `no sideloaded accounts found with tenant_id+id ["t_3","u_1"] for Membership.Account`)
+
+	// the scope field is read by the relation, even without a field of its own
+	seat := new(Seat)
+	err = Unmarshal([]byte(`{"tenant_id": "t_2", "account_id": "u_1", "accounts": [{"id": "u_1", "tenant_id": "t_2", "name": "Globex"}]}`),
+		seat, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "Globex", seat.Account.Name)
+
+	err = Unmarshal([]byte(`{}`), new(BadCompoundKey))
+	assert.EqualError(t, err, "BadCompoundKey.Account: hasone relation Account has 2 id fields but 1 lookup keys")
+}
+
+func TestUnmarshalMany(t *testing.T) {
+	data := []byte(`{
+		"orders": [{"id": 1, "customer_id": 7}, {"id": 2, "customer_id": 7}, {"id": 3}],
+		"customers": [{"id": 7, "name": "Ann", "order_ids": [1, 2]}]
+	}`)
+	var orders []*Order
+	err := UnmarshalMany(data, "orders", &orders)
+	assert.Nil(t, err)
+	assert.Len(t, orders, 3)
+	assert.Equal(t, "Ann", orders[0].Customer.Name)
+	assert.True(t, orders[0].Customer == orders[1].Customer)
+	assert.True(t, orders[0].Customer.Orders[1] == orders[1])
+	assert.Nil(t, orders[2].Customer)
+
+	orders = nil
+	err = UnmarshalMany([]byte(`{"customers": []}`), "orders", &orders)
+	assert.Nil(t, err)
+	assert.NotNil(t, orders)
+	assert.Empty(t, orders)
+
+	err = UnmarshalMany([]byte(`{"orders": [1]}`), "orders", &orders)
+	assert.EqualError(t, err, "expecting an object at index 0 of orders, got json.Number")
+	err = UnmarshalMany([]byte(`{"orders": {}}`), "orders", &orders)
+	assert.EqualError(t, err, "expecting an array for orders, got map[string]interface {}")
+	err = UnmarshalMany(data, "orders", new(Order))
+	assert.EqualError(t, err, "expecting pointer to a slice of pointers for orders, got *jsonsideload.Order")
+}
+
+func TestUnmarshalByID(t *testing.T) {
+	data := []byte(`{
+		"result": 2,
+		"orders": [{"id": 1, "customer_id": 7}, {"id": 2, "customer_id": 7}],
+		"customers": [{"id": 7, "name": "Ann", "order_ids": [1, 2]}]
+	}`)
+	order := new(Order)
+	err := UnmarshalByID(data, "orders", 2, order)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), order.ID)
+	assert.Equal(t, "Ann", order.Customer.Name)
+	assert.True(t, order.Customer.Orders[1] == order)
+
+	err = UnmarshalByID(data, "orders", "3", new(Order))
+	assert.EqualError(t, err, "no orders found with id 3")
+	err = UnmarshalByID([]byte(`[]`), "orders", 2, new(Order))
+	assert.EqualError(t, err, "malformed JSON provided: expecting an object, got []interface {}")
+}
+
+func TestUnmarshalWithSource(t *testing.T) {
+	order := new(Order)
+	source, err := UnmarshalWithSource([]byte(`{
+		"id": 1,
+		"customer_id": 7,
+		"customers": [{"id": 7, "name": "Ann"}],
+		"meta": {"request_id": "r_1", "took": 12}
+	}`), order)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+	assert.Equal(t, map[string]interface{}{"request_id": "r_1", "took": json.Number("12")}, source["meta"])
+
+	var orders []*Order
+	source, err = UnmarshalWithSource([]byte(`[{"id": 1}]`), &orders)
+	assert.Nil(t, err)
+	assert.Nil(t, source)
+	assert.Len(t, orders, 1)
+
+	source, err = UnmarshalWithSource([]byte(`{"id": "one"}`), new(Order))
+	assert.NotNil(t, err)
+	assert.Equal(t, "one", source["id"])
+}
+
+func TestUnmarshalMap(t *testing.T) {
+	// as a YAML decoder parses "id: 1\ncustomer_id: 7\ncustomers:\n  - {id: 7, name: Ann, order_ids: [1]}\norders: [{id: 1}]"
+	source := map[interface{}]interface{}{
+		"id":          1,
+		"customer_id": 7,
+		"customers":   []interface{}{map[interface{}]interface{}{"id": 7, "name": "Ann", "order_ids": []interface{}{uint8(1)}}},
+		"orders":      []interface{}{map[string]interface{}{"id": 1.0}},
+	}
+	order := new(Order)
+	err := UnmarshalMap(source, order)
+	assert.Nil(t, err)
+	expected := new(Order)
+	assert.Nil(t, Unmarshal([]byte(`{
+		"id": 1,
+		"customer_id": 7,
+		"customers": [{"id": 7, "name": "Ann", "order_ids": [1]}],
+		"orders": [{"id": 1}]
+	}`), expected))
+	assert.Equal(t, expected, order)
+	assert.Equal(t, float64(1), order.Customer.Orders[0].ID)
+
+	var orders []*Order
+	err = UnmarshalMap([]interface{}{map[string]interface{}{"id": int64(2)}}, &orders)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Order{{ID: 2}}, orders)
+
+	err = UnmarshalMap(map[string]interface{}{"id": math.NaN()}, new(Order))
+	assert.EqualError(t, err, "malformed payload provided: unsupported number NaN")
+}
+
+func TestUnmarshalEmbeddedRelations(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"title": "Spec",
+		"created_by_id": "u_123",
+		"updated_by_id": "u_456",
+		"owner_ids": ["u_123"],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	doc := new(Document)
+	err := Unmarshal(data, doc)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), doc.ID)
+	assert.Equal(t, "Acme", doc.CreatedBy.Name)
+	assert.Equal(t, "Globex", doc.UpdatedBy.Name)
+	assert.True(t, doc.CreatedBy == doc.Owners[0])
+
+	out, err := Marshal(doc)
+	assert.Nil(t, err)
+	roundTripped := new(Document)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, doc, roundTripped)
+	assert.Equal(t, "Globex", doc.UpdatedBy.Name) // marshaling leaves the embedded pointer untouched
+
+	// the outer field shadows the embedded one, as in Go
+	draft := new(Draft)
+	err = Unmarshal([]byte(`{"created_by_id": "u_123", "author_id": "u_456", "accounts": [{"id": "u_123"}, {"id": "u_456"}]}`), draft)
+	assert.Nil(t, err)
+	assert.Equal(t, "u_456", draft.CreatedBy.ID)
+	assert.Nil(t, draft.BaseModel.CreatedBy)
+}
+
+func TestUnmarshalUnknownAnnotation(t *testing.T) {
+	err := Unmarshal([]byte(`{"account_id": "u_123", "accounts": [{"id": "u_123"}]}`), new(Misspelled))
+	assert.EqualError(t, err, `Misspelled.Account: unknown jsonsideload annotation "hasOne" on field Account`)
+	_, err = Marshal(new(Misspelled))
+	assert.EqualError(t, err, `unknown jsonsideload annotation "hasOne" on field Account`)
+}
+
+func TestUnmarshalUnexportedTaggedField(t *testing.T) {
+	type hidden struct {
+		ID      string   `json:"id"`
+		account *Account `jsonsideload:"hasone,accounts,account_id"`
+	}
+	model := new(hidden)
+	err := Unmarshal([]byte(`{"id": "1", "account_id": "u_123", "accounts": [{"id": "u_123"}]}`), model)
+	assert.EqualError(t, err, "hidden.account: jsonsideload tag on unexported field account, which cannot be set")
+	assert.Nil(t, model.account)
+	_, err = Marshal(&hidden{ID: "1", account: &Account{ID: "u_123"}})
+	assert.EqualError(t, err, "jsonsideload tag on unexported field account, which cannot be set")
+}
+
+func TestUnmarshalIncludesSkipsNonObjects(t *testing.T) {
+	gallery := new(Gallery)
+	err := Unmarshal([]byte(`{"photos": [{"url": "a.png"}, null, "b.png", 3, {"url": "c.png"}]}`), gallery)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Photo{{URL: "a.png"}, {URL: "c.png"}}, gallery.Photos)
+}
+
+func TestUnmarshalDeepIncludes(t *testing.T) {
+	data := []byte(`{
+		"rows": [
+			{"cells": [{"values": [{"v": 1}, null, {"v": 2}]}, null, {"values": []}]},
+			null,
+			{"cells": [{"values": [{"v": 3}]}], "owner_id": "u_123"}
+		],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	grid := new(Grid)
+	err := Unmarshal(data, grid)
+	assert.Nil(t, err)
+	assert.Len(t, grid.Rows, 2)
+	assert.Len(t, grid.Rows[0].Cells, 2)
+	assert.Equal(t, []*CellValue{{V: 1}, {V: 2}}, grid.Rows[0].Cells[0].Values)
+	assert.Empty(t, grid.Rows[0].Cells[1].Values)
+	assert.Equal(t, []*CellValue{{V: 3}}, grid.Rows[1].Cells[0].Values)
+	assert.Equal(t, "Acme", grid.Rows[1].Owner.Name)
+}
+
+func TestUnmarshalMixedIDTypes(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"customer_id": "7",
+		"orders": [{"id": 1.0, "customer_id": 7}],
+		"customers": [{"id": 7.0, "name": "Ann", "order_ids": [1, "1"]}]
+	}`)
+	order := new(Order)
+	err := Unmarshal(data, order, WithStrictRelations(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+	assert.Len(t, order.Customer.Orders, 2)
+	assert.True(t, order.Customer.Orders[0] == order.Customer.Orders[1])
+	assert.True(t, order.Customer.Orders[0].Customer == order.Customer)
+}
+
+func TestDecodeInto(t *testing.T) {
+	pool := sync.Pool{New: func() interface{} { return new(Order) }}
+	order := pool.Get().(*Order)
+	err := DecodeInto([]byte(`{"id": 1, "customer_id": 7, "customers": [{"id": 7, "name": "Ann"}]}`), order)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+	pool.Put(order)
+
+	order = pool.Get().(*Order)
+	err = DecodeInto([]byte(`{"id": 2}`), order)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), order.ID)
+	assert.Nil(t, order.Customer)
+
+	// Unmarshal leaves what the payload does not mention untouched
+	order.Customer = &Customer{Name: "stale"}
+	assert.Nil(t, Unmarshal([]byte(`{"id": 3}`), order))
+	assert.Equal(t, "stale", order.Customer.Name)
+}
+
+func TestUnmarshalErrorFieldPath(t *testing.T) {
+	err := Unmarshal([]byte(`{"partners": [{"id": 1, "count_id": 2}]}`), new(Agency))
+	assert.EqualError(t, err, "Agency.Partners[0].Count: expecting pointer type for Count in struct")
+	var fieldErr *FieldError
+	if assert.True(t, errors.As(err, &fieldErr)) {
+		assert.Equal(t, "Agency.Partners[0].Count", fieldErr.Field)
+	}
+	assert.True(t, errors.Is(err, ErrExpectPointer))
+
+	err = Unmarshal([]byte(`{"id": 1}`), new(struct {
+		Tags *Tag `jsonsideload:"includes,tags"`
+	}))
+	assert.True(t, errors.Is(err, ErrExpectPointerSlice))
+
+	err = Unmarshal([]byte(`{"partners": [{"id": "one"}]}`), new(Agency))
+	assert.EqualError(t, err, "Agency.Partners[0]: json: cannot unmarshal string into Go struct field Partner.id of type float64")
+}
+
+func TestUnmarshalModelValidation(t *testing.T) {
+	data := []byte(`{"id": 1, "customer_id": 7, "customers": [{"id": 7, "name": "Ann"}]}`)
+	var nilOrder *Order
+	number := 3
+	for _, model := range []interface{}{nil, Order{}, nilOrder, &number, new([]*Order)} {
+		err := Unmarshal(data, model)
+		assert.EqualError(t, err, fmt.Sprintf("model must be a non-nil pointer to struct, got %T", model))
+	}
+
+	var order *Order
+	err := Unmarshal(data, &order)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+
+	orderPtr := &order
+	err = Unmarshal([]byte(`{"id": 2}`), &orderPtr)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), order.ID)
+}
+
+func TestUnmarshalHasManyReverse(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"line_items": [
+			{"id": 10, "cart_id": 1, "quantity": 2},
+			{"id": 11, "cart_id": 2, "quantity": 5},
+			{"id": 12, "cart_id": 1, "quantity": 1}
+		]
+	}`)
+	cart := new(Cart)
+	err := Unmarshal(data, cart)
+	assert.Nil(t, err)
+	if assert.Len(t, cart.LineItems, 2) {
+		assert.Equal(t, float64(10), cart.LineItems[0].ID)
+		assert.Equal(t, float64(12), cart.LineItems[1].ID)
+	}
+
+	// the key pointed back at is read by the relation, even without a field of its own
+	tab := new(Tab)
+	err = Unmarshal(data, tab, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Len(t, tab.LineItems, 2)
+
+	empty := new(Cart)
+	err = Unmarshal([]byte(`{"id": 3, "line_items": []}`), empty)
+	assert.Nil(t, err)
+	assert.NotNil(t, empty.LineItems)
+	assert.Empty(t, empty.LineItems)
+}
+
+func TestUnmarshalContinueOnError(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [
+			{"id": "s_1", "account_id": "u_1", "manager_ids": ["u_1", "u_2", "u_3"]},
+			{"id": "s_2", "plan": 7},
+			{"id": "s_3", "plan": "gold"}
+		],
+		"accounts": [{"id": "u_1", "name": "Acme"}, {"id": "u_2", "name": 42}, {"id": "u_3", "name": "Globex"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp)
+	assert.NotNil(t, err)
+
+	resp = new(SubscriptionResponse)
+	err = Unmarshal(data, resp, WithContinueOnError(true))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "SubscriptionResponse.Subscriptions[0].Managers[1]: json: cannot unmarshal number")
+		assert.Contains(t, err.Error(), "SubscriptionResponse.Subscriptions[1]: json: cannot unmarshal number")
+		var typeErr *json.UnmarshalTypeError
+		assert.True(t, errors.As(err, &typeErr))
+	}
+	if assert.Len(t, resp.Subscriptions, 2) {
+		assert.Equal(t, "s_1", resp.Subscriptions[0].ID)
+		assert.Equal(t, "Acme", resp.Subscriptions[0].Account.Name)
+		assert.Len(t, resp.Subscriptions[0].Managers, 2)
+		assert.Equal(t, "s_3", resp.Subscriptions[1].ID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = UnmarshalContext(ctx, data, new(SubscriptionResponse), WithContinueOnError(true))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestUnmarshalHasOneBackReference(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"accounts": [{"id": "u_1", "order_id": 2, "name": "Globex"}, {"id": "u_2", "order_id": 1, "name": "Acme"}]
+	}`)
+	purchase := new(Purchase)
+	err := Unmarshal(data, purchase)
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", purchase.Account.Name)
+
+	purchase = new(Purchase)
+	err = Unmarshal([]byte(`{"id": 3, "accounts": [{"id": "u_1", "order_id": 2}]}`), purchase, WithStrictRelations(true))
+	assert.EqualError(t, err, "no sideloaded accounts found with order_id 3 for Purchase.Account")
+
+	data = []byte(`{"id": 1, "accounts": [{"id": "u_1", "order_id": 1}, {"id": "u_2", "order_id": "1"}]}`)
+	err = Unmarshal(data, new(Purchase))
+	assert.EqualError(t, err, "2 sideloaded accounts with order_id 1 for Purchase.Account, expecting one")
+
+	// the key pointed back at is read by the relation, even without a field of its own
+	booking := new(Booking)
+	err = Unmarshal([]byte(`{"id": 1, "line_items": [{"id": 5, "cart_id": 1, "quantity": 2}]}`), booking,
+		WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, booking.Item.Quantity)
+}
+
+func TestUnmarshalCollectionKeyedByID(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_456", "u_123"]}],
+		"accounts": {"u_123": {"name": "Acme"}, "u_456": {"id": "u_456", "name": "Globex"}}
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp)
+	assert.Nil(t, err)
+	subscription := resp.Subscriptions[0]
+	assert.Equal(t, "Acme", subscription.Account.Name)
+	if assert.Len(t, subscription.Managers, 2) {
+		assert.Equal(t, "Globex", subscription.Managers[0].Name)
+		assert.Same(t, subscription.Account, subscription.Managers[1])
+	}
+
+	order := new(Order)
+	err = Unmarshal([]byte(`{"id": 1, "customer_id": 7, "customers": {"7": {"id": 7, "name": "Ann"}}}`), order)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+}
+
+func TestUnmarshalHasManyKeepsIDOrder(t *testing.T) {
+	names := func(cities []*City) []string {
+		var names []string
+		for _, city := range cities {
+			names = append(names, city.Name)
+		}
+		return names
+	}
+	ids := `"current_city_id": 2, "lived_city_ids": [3, 1, 2, 1, 3]`
+	for name, cities := range map[string]string{
+		"array":  `[{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "c"}]`,
+		"object": `{"1": {"name": "a"}, "2": {"name": "b"}, "3": {"name": "c"}}`,
+	} {
+		// the current city is decoded first, so the instance cache already holds b
+		person := new(Person)
+		err := Unmarshal([]byte(`{`+ids+`, "cities": `+cities+`}`), person)
+		assert.Nil(t, err, name)
+		assert.Equal(t, []string{"c", "a", "b", "a", "c"}, names(person.LivedCities), name)
+		assert.Same(t, person.CurrentCity, person.LivedCities[2], name)
+
+		person = new(Person)
+		err = Unmarshal([]byte(`{`+ids+`, "cities": `+cities+`}`), person, WithDedupeRelations(true))
+		assert.Nil(t, err, name)
+		assert.Equal(t, []string{"c", "a", "b"}, names(person.LivedCities), name)
+	}
+}
+
+func TestUnmarshalHasOneObjectReference(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"account": {"id": "u_123", "type": "account"},
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	reservation := new(Reservation)
+	err := Unmarshal(data, reservation)
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", reservation.Account.Name)
+
+	reservation = new(Reservation)
+	err = Unmarshal([]byte(`{"id": 2, "account": {"type": "account"}, "accounts": []}`), reservation)
+	assert.Nil(t, err)
+	assert.Nil(t, reservation.Account)
+}
+
+func TestUnmarshalRelationError(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"payments": ["12.50 USD", "bogus"],
+		"refund_id": 3,
+		"refunds": [{"id": 3, "amount": "1.00 USD"}]
+	}`)
+	err := Unmarshal(data, new(Invoice))
+	var relationErr *RelationError
+	if assert.True(t, errors.As(err, &relationErr)) {
+		assert.Equal(t, "payments", relationErr.Relation)
+		assert.Equal(t, "Invoice.Payments[1]", relationErr.Field)
+	}
+
+	data = []byte(`{
+		"subscriptions": [{"id": "s_1", "manager_ids": ["u_1", "u_2"]}],
+		"accounts": [{"id": "u_1", "name": "Acme"}, {"id": "u_2", "name": 42}]
+	}`)
+	err = Unmarshal(data, new(SubscriptionResponse))
+	assert.EqualError(t, err, "SubscriptionResponse.Subscriptions[0].Managers[1]: json: cannot unmarshal number into Go struct field Account.name of type string")
+	if assert.True(t, errors.As(err, &relationErr)) {
+		assert.Equal(t, "accounts", relationErr.Relation)
+		assert.Equal(t, "SubscriptionResponse.Subscriptions[0].Managers[1]", relationErr.Field)
+	}
+	var typeErr *json.UnmarshalTypeError
+	assert.True(t, errors.As(err, &typeErr))
+}
+
+func TestUnmarshalSetLoaded(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"owner_id": "u_404",
+		"reader_ids": ["u_1"],
+		"label": null,
+		"accounts": [{"id": "u_1", "name": "Acme"}]
+	}`)
+	shelf := new(Shelf)
+	err := Unmarshal(data, shelf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Readers"}, shelf.loaded)
+
+	shelf = new(Shelf)
+	err = Unmarshal([]byte(`{"id": 2, "owner_id": "u_1", "label": {"name": "new"}, "accounts": [{"id": "u_1"}]}`), shelf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Owner", "Label"}, shelf.loaded)
+}
+
+func TestUnmarshalFlatten(t *testing.T) {
+	data := []byte(`{"items": [{"name": "a"}], "meta": {"total": 10, "PAGE": 2}}`)
+	page := new(Page)
+	err := Unmarshal(data, page, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, page.Total)
+	assert.Equal(t, 2, page.Page)
+	assert.Len(t, page.Items, 1)
+
+	err = Unmarshal([]byte(`{"meta": {"total": "ten"}}`), new(Page))
+	assert.EqualError(t, err, "Page.Total: json: cannot unmarshal string into Go value of type int")
+
+	page = new(Page)
+	err = Unmarshal([]byte(`{"total": 99, "meta": {"page": 3}}`), page)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, page.Total)
+	assert.Equal(t, 3, page.Page)
+}
+
+func TestUnmarshalReader(t *testing.T) {
+	order := new(Order)
+	err := UnmarshalReader(strings.NewReader(`{"id": 1, "customer_id": 7, "customers": [{"id": 7, "name": "Ann"}]}`), order)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+
+	err = UnmarshalReader(iotest.ErrReader(io.ErrUnexpectedEOF), new(Order))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.EqualError(t, err, "reading the payload: unexpected EOF")
+}
+
+func TestUnmarshalTwoHopSideload(t *testing.T) {
+	// the wallet nests a transactions key of its own, which must not shadow the array sideloaded at the root
+	data := []byte(`{
+		"id": 1,
+		"wallet_id": 5,
+		"wallets": [{"id": 5, "transaction_ids": [7, 8], "transactions": [{"id": 7, "amount": -1}]}],
+		"transactions": [{"id": 7, "amount": 100}, {"id": 8, "amount": 250}]
+	}`)
+	transfer := new(Transfer)
+	err := Unmarshal(data, transfer)
+	assert.Nil(t, err)
+	if assert.Len(t, transfer.Wallet.Transactions, 2) {
+		assert.Equal(t, 100, transfer.Wallet.Transactions[0].Amount)
+		assert.Equal(t, 250, transfer.Wallet.Transactions[1].Amount)
+	}
+
+	var transfers []*Transfer
+	err = UnmarshalMany([]byte(`{"transfers": [`+string(data)+`], "wallets": [], "transactions": []}`), "transfers", &transfers)
+	assert.Nil(t, err)
+	assert.Nil(t, transfers[0].Wallet)
+}
+
+func TestUnmarshalRaw(t *testing.T) {
+	envelope := new(Envelope)
+	err := Unmarshal([]byte(`{
+		"id": 1,
+		"account_id": "u_1",
+		"watcher_ids": ["u_2", "u_3", "u_1"],
+		"metadata": {"source": "import", "tags": ["a", "b"]},
+		"revisions": [{"n": 1}, {"n": 2}],
+		"accounts": [
+			{"id": "u_1", "name": "Acme", "balance": 12.50},
+			{"id": "u_2", "name": "<Bolt>"}
+		]
+	}`), envelope)
+	assert.Nil(t, err)
+	// the bytes are kept as written, whitespace, key order and numbers included
+	assert.Equal(t, `{"id": "u_1", "name": "Acme", "balance": 12.50}`, string(envelope.Account))
+	if assert.Len(t, envelope.Watchers, 2) { // u_3 is not sideloaded
+		assert.Equal(t, `{"id": "u_2", "name": "<Bolt>"}`, string(envelope.Watchers[0]))
+		assert.Equal(t, string(envelope.Account), string(envelope.Watchers[1]))
+	}
+	assert.Equal(t, `{"source": "import", "tags": ["a", "b"]}`, string(envelope.Metadata))
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`{"n": 1}`), json.RawMessage(`{"n": 2}`)}, envelope.Revisions)
+
+	envelope = new(Envelope)
+	err = Unmarshal([]byte(`{
+		"account_id": "u_1", "metadata": "caf\u00e9", "revisions": [ 1.0 , {"z":1,  "a":2} ],
+		"accounts": [{"name": "\u003cAcme\u003e", "id": "u_1"}], "metadata": { "b" : [ ] }
+	}`), envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name": "\u003cAcme\u003e", "id": "u_1"}`, string(envelope.Account))
+	assert.Equal(t, `{ "b" : [ ] }`, string(envelope.Metadata)) // the last of repeated keys
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`1.0`), json.RawMessage(`{"z":1,  "a":2}`)}, envelope.Revisions)
+
+	// objects the payload does not hold as such are encoded from their maps
+	envelope = new(Envelope)
+	err = Unmarshal([]byte(`{"account_id": "u_1", "accounts": []}`), envelope,
+		WithResolver("accounts", func(id interface{}) (map[string]interface{}, error) {
+			return map[string]interface{}{"id": id, "name": "acme"}, nil
+		}))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"u_1","name":"acme"}`, string(envelope.Account))
+	err = UnmarshalMap(map[string]interface{}{"metadata": map[string]interface{}{"a": 1}}, envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1}`, string(envelope.Metadata))
+
+	strict := new(Envelope)
+	err = Unmarshal([]byte(`{"id": 1, "account_id": "u_9", "accounts": []}`), strict, WithStrictRelations(true))
+	var unresolved *UnresolvedRelationError
+	assert.True(t, errors.As(err, &unresolved))
+
+	type badRaw struct {
+		Account *Account `jsonsideload:"raw,accounts,account_id"`
+	}
+	err = Unmarshal([]byte(`{"account_id": "u_1"}`), new(badRaw))
+	assert.EqualError(t, err, "badRaw.Account: expecting json.RawMessage or []json.RawMessage for Account in struct")
+}
+
+func TestUnmarshalCandidateCollections(t *testing.T) {
+	var documents []*Asset
+	err := Unmarshal([]byte(`[
+		{"id": 1, "owner_id": 7, "owner_type": "users", "users": [{"id": 7, "name": "Ann"}], "organizations": [{"id": 7, "name": "Acme"}]},
+		{"id": 2, "owner_id": 7, "owner_type": "organizations", "organizations": [{"id": 7, "name": "Acme"}]},
+		{"id": 3, "owner_id": 7, "owner_type": "teams", "organizations": [{"id": 7, "name": "Acme"}]}
+	]`), &documents)
+	assert.Nil(t, err)
+	if assert.Len(t, documents, 3) {
+		assert.Equal(t, "Ann", documents[0].Owner.Name)
+		assert.Equal(t, "Acme", documents[1].Owner.Name)
+		assert.Nil(t, documents[2].Owner)
+	}
+
+	err = Unmarshal([]byte(`{"id": 3, "owner_id": 7, "owner_type": "teams"}`), new(Asset), WithStrictRelations(true))
+	assert.EqualError(t, err, `Asset.Owner: unknown owner_type "teams" for relation users|organizations`)
+
+	type noDiscriminator struct {
+		Owner *Party `jsonsideload:"hasone,users|organizations,owner_id"`
+	}
+	err = Unmarshal([]byte(`{"owner_id": 7}`), new(noDiscriminator))
+	assert.EqualError(t, err, "noDiscriminator.Owner: hasone relation Owner requires a discriminator field to pick one of users|organizations")
+}
+
+func TestUnmarshalNamedCollectionTypes(t *testing.T) {
+	catalog := new(Catalog)
+	err := Unmarshal([]byte(`{
+		"tag_ids": ["go", "json"],
+		"featured": [{"name": "new"}],
+		"owner_ids": ["u_1"],
+		"notes": [{"text": "a"}],
+		"tags": [{"name": "go"}, {"name": "json"}],
+		"accounts": [{"id": "u_1", "name": "Acme"}]
+	}`), catalog)
+	assert.Nil(t, err)
+	assert.Equal(t, Tags{{Name: "go"}, {Name: "json"}}, catalog.Tags)
+	assert.Equal(t, Tags{{Name: "new"}}, catalog.Featured)
+	assert.Equal(t, "Acme", catalog.Owners["u_1"].Name)
+	assert.Equal(t, RawMessages{json.RawMessage(`{"text": "a"}`)}, catalog.Notes)
+
+	out, err := Marshal(catalog)
+	assert.Nil(t, err)
+	roundTripped := new(Catalog)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	// Marshal compacts raw JSON, as encoding/json does
+	assert.Equal(t, RawMessages{json.RawMessage(`{"text":"a"}`)}, roundTripped.Notes)
+	roundTripped.Notes = catalog.Notes
+	assert.Equal(t, catalog, roundTripped)
+}
+
+func TestUnmarshalConditionalRelation(t *testing.T) {
+	var stats Stats
+	parcel := new(Parcel)
+	err := Unmarshal([]byte(`{"id": 1, "has_shipment": false, "shipment_id": 9}`), parcel, WithStrictRelations(true), WithStats(&stats))
+	assert.Nil(t, err)
+	assert.Nil(t, parcel.Shipment)
+	assert.Equal(t, Stats{}, stats)
+
+	err = Unmarshal([]byte(`{"id": 1, "has_shipment": true, "shipment_id": 9, "shipments": [{"id": 9}]}`), parcel)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(9), parcel.Shipment.ID)
+
+	type badModifier struct {
+		Shipment *Shipment `jsonsideload:"hasone,shipments,shipment_id;unless=has_shipment"`
+	}
+	err = Unmarshal([]byte(`{}`), new(badModifier))
+	assert.EqualError(t, err, `badModifier.Shipment: unknown jsonsideload modifier "unless=has_shipment" on field Shipment`)
+}
+
+func TestUnmarshalIncludeFirstAndLast(t *testing.T) {
+	contact := new(Contact)
+	err := Unmarshal([]byte(`{"name": "Ann", "addresses": [{"street": "Main"}, {"street": "Side"}, {"street": "High"}]}`), contact)
+	assert.Nil(t, err)
+	assert.Equal(t, &Address{Street: "Main"}, contact.Primary)
+	assert.Equal(t, &Address{Street: "High"}, contact.Latest)
+
+	for _, data := range []string{`{"addresses": []}`, `{"addresses": null}`, `{}`} {
+		contact := new(Contact)
+		assert.Nil(t, Unmarshal([]byte(data), contact), data)
+		assert.Nil(t, contact.Primary, data)
+		assert.Nil(t, contact.Latest, data)
+	}
+}
+
+func TestUnmarshalCount(t *testing.T) {
+	catalog := new(Catalog)
+	err := Unmarshal([]byte(`{"tag_ids": ["go", "json", "go"], "owner_ids": ["u_1"], "tags": [{"name": "go"}]}`), catalog)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, catalog.TagCount) // the ids, whether sideloaded or not
+	assert.Equal(t, uint8(1), catalog.Owned)
+
+	catalog = new(Catalog)
+	assert.Nil(t, Unmarshal([]byte(`{"tag_ids": null}`), catalog))
+	assert.Zero(t, catalog.TagCount)
+
+	type badCount struct {
+		Tags string `jsonsideload:"count,tag_ids"`
+	}
+	err = Unmarshal([]byte(`{}`), new(badCount))
+	assert.EqualError(t, err, "badCount.Tags: expecting an integer type for Tags in struct")
+}
+
+func TestUnmarshalRest(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"title": "Lamp",
+		"seller_id": "u_1",
+		"color": "red",
+		"dimensions": {"height": 40},
+		"accounts": [{"id": "u_1", "name": "Acme"}]
+	}`)
+	listing := new(Listing)
+	err := Unmarshal(data, listing, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "u_1", listing.Seller.ID)
+	assert.Equal(t, map[string]interface{}{"color": "red", "dimensions": map[string]interface{}{"height": float64(40)}}, listing.Extra)
+
+	out, err := Marshal(listing)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), string(out))
+
+	type twoRests struct {
+		Extra map[string]interface{} `jsonsideload:"rest"`
+		More  map[string]interface{} `jsonsideload:"rest"`
+	}
+	err = Unmarshal([]byte(`{"color": "red"}`), new(twoRests))
+	assert.EqualError(t, err, "twoRests.More: only one rest field allowed per struct, got Extra and More")
+}
+
+func TestUnmarshalWhereFilters(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"item_ids": [1, 2, 3],
+		"gift_id": 3,
+		"line_items": [
+			{"id": 1, "quantity": 1, "status": "active"},
+			{"id": 2, "quantity": 2, "status": "cancelled"},
+			{"id": 3, "quantity": 3, "status": "active", "gift": true}
+		]
+	}`)
+	shipping := new(Shipping)
+	err := Unmarshal(data, shipping, WithStrictRelations(true))
+	assert.Nil(t, err)
+	if assert.Len(t, shipping.ActiveItems, 2) {
+		assert.Equal(t, float64(1), shipping.ActiveItems[0].ID)
+		assert.Equal(t, float64(3), shipping.ActiveItems[1].ID)
+	}
+	assert.Same(t, shipping.ActiveItems[1], shipping.Gift)
+
+	shipping = new(Shipping)
+	err = Unmarshal([]byte(`{"id": 1, "gift_id": 1, "line_items": [{"id": 1, "status": "active"}]}`), shipping)
+	assert.Nil(t, err)
+	assert.Nil(t, shipping.Gift) // not a gift
+}
+
+func TestUnmarshalFixedSizeArrayRelations(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"corner_ids": [1, 2, 3],
+		"anchors": [{"id": 9, "x": 0.5, "y": 0.5}],
+		"corners": [{"id": 1, "x": 0, "y": 0}, {"id": 2, "x": 1, "y": 0}, {"id": 3, "x": 0, "y": 1}]
+	}`)
+	polygon := new(Polygon)
+	err := Unmarshal(data, polygon)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), polygon.Corners[0].ID)
+	assert.Equal(t, float64(1), polygon.Corners[1].X)
+	assert.Equal(t, float64(1), polygon.Corners[2].Y)
+	assert.Equal(t, [2]*Corner{{ID: 9, X: 0.5, Y: 0.5}}, polygon.Anchors)
+
+	out, err := Marshal(polygon)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), string(out))
+
+	polygon = new(Polygon)
+	err = Unmarshal([]byte(`{"id": 1, "corner_ids": [1], "corners": [{"id": 1}]}`), polygon)
+	assert.Nil(t, err)
+	assert.NotNil(t, polygon.Corners[0])
+	assert.Nil(t, polygon.Corners[1])
+
+	err = Unmarshal([]byte(`{"id": 1, "corner_ids": [1, 2, 3, 4], "corners": [{"id": 1}, {"id": 2}, {"id": 3}, {"id": 4}]}`),
+		new(Polygon))
+	assert.EqualError(t, err, "Polygon.Corners: 4 relation items, more than the length 3 of the array")
+}
+
+func TestUnmarshalZip(t *testing.T) {
+	data := []byte(`{"id": 1, "prices": [9.5, 20], "labels": ["basic", "pro"]}`)
+	list := new(PriceList)
+	err := Unmarshal(data, list, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, []*PriceLabel{{Price: 9.5, Label: "basic"}, {Price: 20, Label: "pro"}}, list.Prices)
+
+	out, err := Marshal(list)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), string(out))
+
+	list = new(PriceList)
+	err = Unmarshal([]byte(`{"id": 1, "prices": [9.5, 20], "labels": ["basic"]}`), list)
+	assert.Nil(t, err)
+	assert.Equal(t, []*PriceLabel{{Price: 9.5, Label: "basic"}, {Price: 20}}, list.Prices)
+
+	list = new(PriceList)
+	err = Unmarshal([]byte(`{"id": 1}`), list)
+	assert.Nil(t, err)
+	assert.Nil(t, list.Prices)
+
+	err = Unmarshal([]byte(`{"id": 1, "prices": ["free"], "labels": ["basic"]}`), new(PriceList))
+	assert.EqualError(t, err, "PriceList.Prices[0]: json: cannot unmarshal string into Go struct field PriceLabel.prices of type float64")
+}
+
+func TestUnmarshalRelationAttributes(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"items": [{"id": 10, "quantity": 2}, {"id": 11, "quantity": 1}, {"id": 10, "quantity": 5}],
+		"catalog_items": [{"id": 10, "name": "pen"}, {"id": 11, "name": "ink"}]
+	}`)
+	receipt := new(Receipt)
+	err := Unmarshal(data, receipt, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	if assert.Len(t, receipt.Items, 3) {
+		assert.Equal(t, 2, receipt.Items[0].Quantity)
+		assert.Equal(t, "pen", receipt.Items[0].Item.Name)
+		assert.Equal(t, "ink", receipt.Items[1].Item.Name)
+		assert.Equal(t, 5, receipt.Items[2].Quantity)
+		assert.Same(t, receipt.Items[0].Item, receipt.Items[2].Item)
+	}
+
+	out, err := Marshal(receipt)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), string(out))
+}
+
+func TestUnmarshalBigIntIDs(t *testing.T) {
+	data := []byte(`{
+		"id": 1234567890123456789012345678901234567890,
+		"owner_id": 9876543210987654321098765432109876543210,
+		"vaults": [
+			{"id": 9876543210987654321098765432109876543211, "balance": 1.5},
+			{"id": 9876543210987654321098765432109876543210, "balance": 0.1}
+		]
+	}`)
+	token := new(Token)
+	err := Unmarshal(data, token, WithReferenceIDs(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "1234567890123456789012345678901234567890", token.ID.String())
+	assert.Equal(t, "9876543210987654321098765432109876543210", token.OwnerID.String())
+	if assert.NotNil(t, token.Owner) {
+		assert.Equal(t, "9876543210987654321098765432109876543210", token.Owner.ID.String())
+		assert.Equal(t, "0.1", token.Owner.Balance.String())
+	}
+
+	out, err := Marshal(token)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"owner_id":9876543210987654321098765432109876543210`)
+
+	id, _ := new(big.Int).SetString("9876543210987654321098765432109876543211", 10)
+	vault := new(Vault)
+	assert.Nil(t, UnmarshalByID(data, "vaults", id, vault))
+	assert.Equal(t, "1.5", vault.Balance.String())
+}
+
+func TestUnmarshalGenericTypes(t *testing.T) {
+	data := []byte(`{
+		"total": 2,
+		"items": [{"id": 1, "customer_id": 7}, {"id": 2, "customer_id": 7}],
+		"customers": [{"id": 7, "name": "Ann", "order_ids": []}]
+	}`)
+	page := new(ResultPage[Order])
+	err := Unmarshal(data, page)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, page.Total)
+	if assert.Len(t, page.Items, 2) {
+		assert.Equal(t, "Ann", page.Items[0].Customer.Name)
+		assert.Same(t, page.Items[0].Customer, page.Items[1].Customer)
+	}
+
+	out, err := Marshal(page)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), string(out))
+
+	lookup := new(Lookup[string, Customer])
+	err = Unmarshal([]byte(`{"key": "k", "value_id": 7, "customers": [{"id": 7, "name": "Ann"}]}`), lookup)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", lookup.Value.Name)
+
+	err = Unmarshal([]byte(`{"items": [{"id": "one"}]}`), new(ResultPage[Order]))
+	assert.EqualError(t, err, "ResultPage[Order].Items[0]: json: cannot unmarshal string into Go struct field Order.id of type float64")
+}
+
+func TestUnmarshalSelfInclude(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"title": "Spring",
+		"version": "2.0",
+		"owner_id": 7,
+		"customers": [{"id": 7, "name": "Ann", "order_ids": []}]
+	}`)
+	release := new(Release)
+	err := Unmarshal(data, release, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "Spring", release.Title)
+	if assert.NotNil(t, release.Summary) {
+		assert.Equal(t, "2.0", release.Summary.Version)
+		assert.Equal(t, "Ann", release.Summary.Owner.Name)
+	}
+
+	out, err := Marshal(release)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), string(out))
+
+	err = Unmarshal([]byte(`{"id": 1, "extra": true}`), new(Release), WithDisallowUnknownFields(true))
+	assert.EqualError(t, err, `unknown field "extra" in Release`)
+
+	err = Unmarshal([]byte(`{"id": 1}`), new(Mirror))
+	assert.EqualError(t, err, "Mirror.Self.Self: include of the node itself cycles back to Mirror")
+}
+
 // Benchmark Tests
 
 var personResp PersonResponse
@@ -49,6 +1355,59 @@ func BenchmarkUnmarshal(b *testing.B) {
 	}
 }
 
+func BenchmarkUnmarshalLargeHasMany(b *testing.B) {
+	data := prepareLargeTestData(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Unmarshal(data, new(PersonResponse))
+	}
+}
+
+func prepareLeafTestData(n int) []byte {
+	topics := make([]string, n)
+	for i := range topics {
+		topics[i] = fmt.Sprintf(`{"id": %d, "name": "topic %d"}`, i, i)
+	}
+	return []byte(fmt.Sprintf(`{"id": 1, "topics": [%s]}`, strings.Join(topics, ",")))
+}
+
+func BenchmarkUnmarshalLeafIncludes(b *testing.B) {
+	data := prepareLeafTestData(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Unmarshal(data, new(Bundle)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func prepareDeepTestData(depth int) []byte {
+	data := []byte(`{"name": "leaf", "size": 1}`)
+	for i := 0; i < depth; i++ {
+		data = []byte(fmt.Sprintf(`{"name": "folder %d", "size": %d, "parent": %s}`, i, i, data))
+	}
+	return data
+}
+
+func BenchmarkUnmarshalDeepInclude(b *testing.B) {
+	data := prepareDeepTestData(200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Unmarshal(data, new(Folder)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTypePlan(b *testing.B) {
+	personType := reflect.TypeOf(Person{})
+	for i := 0; i < b.N; i++ {
+		typePlan(personType)
+	}
+}
+
 func BenchmarkMarshal(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		json.Marshal(personResp)
@@ -0,0 +1,449 @@
+package jsonsideload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Marshal - builds a sideloaded JSON payload from the given model
+func Marshal(model interface{}, opts ...Option) ([]byte, error) {
+	value := reflect.Indirect(reflect.ValueOf(model))
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expecting pointer to struct, got %T", model)
+	}
+	s := &sideloads{opts: newOptions(opts), collections: make(map[string][]interface{}),
+		seen: make(map[string]map[string]map[string]interface{}), including: make(map[includedStruct]bool)}
+	s.enter(value)
+	rootMap, err := marshalPrimitives(value, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := marshalNode(value, rootMap, s); err != nil {
+		return nil, err
+	}
+	for _, relation := range s.order {
+		setAtPath(rootMap, strings.Split(relation, "."), s.collections[relation])
+	}
+	return json.Marshal(rootMap)
+}
+
+// sideloads - the collections hoisted to the top level of the payload, in order of first use
+type sideloads struct {
+	opts        *options
+	order       []string
+	collections map[string][]interface{}
+	seen        map[string]map[string]map[string]interface{}
+	// including holds the structs on the current path of includes, which would otherwise never end on a cycle
+	including map[includedStruct]bool
+}
+
+// includedStruct - a struct by address and type, telling a struct apart from its first field
+type includedStruct struct {
+	address uintptr
+	typ     reflect.Type
+}
+
+// enter - adds the struct to the include path, reporting false when it already is on it
+func (s *sideloads) enter(value reflect.Value) bool {
+	if !value.CanAddr() {
+		return true // a copy, which cannot be nested in itself
+	}
+	key := includedStruct{value.Addr().Pointer(), value.Type()}
+	if s.including[key] {
+		return false
+	}
+	s.including[key] = true
+	return true
+}
+
+// leave - removes the struct from the include path
+func (s *sideloads) leave(value reflect.Value) {
+	if value.CanAddr() {
+		delete(s.including, includedStruct{value.Addr().Pointer(), value.Type()})
+	}
+}
+
+// add - appends the node to the relation's collection, returning the node already there and false if it is
+func (s *sideloads) add(relation string, id interface{}, node map[string]interface{}) (map[string]interface{}, bool) {
+	if s.seen[relation] == nil {
+		s.seen[relation] = make(map[string]map[string]interface{})
+		s.order = append(s.order, relation)
+	}
+	key := fmt.Sprint(id)
+	if stored, ok := s.seen[relation][key]; ok {
+		return stored, false
+	}
+	s.seen[relation][key] = node
+	s.collections[relation] = append(s.collections[relation], node)
+	return node, true
+}
+
+// marshalPrimitives - marshals the struct with its relation fields left out
+func marshalPrimitives(value reflect.Value, o *options) (map[string]interface{}, error) {
+	valueType := value.Type()
+	primitives := reflect.New(valueType).Elem()
+	primitives.Set(value)
+	var relationKeys []string
+	for _, field := range typePlan(valueType) {
+		fieldType := valueType.FieldByIndex(field.index)
+		if fieldType.PkgPath != "" {
+			continue
+		}
+		zeroField(primitives, field.index)
+		if name := jsonFieldName(fieldType); name != "" {
+			relationKeys = append(relationKeys, name)
+		}
+	}
+	jsonString, err := json.Marshal(primitives.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var node map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonString))
+	decoder.UseNumber()
+	if err := decoder.Decode(&node); err != nil {
+		return nil, err
+	}
+	for _, key := range relationKeys {
+		delete(node, key)
+	}
+	if o.timeLayout != "" {
+		formatTimes(value, node, o.timeLayout)
+	}
+	return node, nil
+}
+
+// formatTimes - rewrites the time fields of the struct in node with the layout
+func formatTimes(value reflect.Value, node map[string]interface{}, layout string) {
+	for _, field := range primitivePlanOf(value.Type()).fields {
+		if _, ok := node[field.name].(string); !ok || !field.isTime {
+			continue
+		}
+		fieldValue, ok := lookupField(value, field.index)
+		if fieldValue = reflect.Indirect(fieldValue); ok && fieldValue.IsValid() {
+			node[field.name] = fieldValue.Interface().(time.Time).Format(layout)
+		}
+	}
+}
+
+// marshalNode - fills the relations of the struct into node, hoisting sideloaded ones into s
+func marshalNode(value reflect.Value, node map[string]interface{}, s *sideloads) error {
+	for _, field := range typePlan(value.Type()) {
+		if field.err != nil {
+			return field.err
+		}
+		fieldValue, ok := lookupField(value, field.index)
+		if !ok || (field.omitEmpty && !isPopulated(fieldValue)) {
+			continue
+		}
+
+		switch field.annotation {
+		case annotationInclude, annotationIncludeFirst, annotationIncludeLast:
+			element, ok := relationStruct(fieldValue)
+			if !ok {
+				continue
+			}
+			child, err := marshalChild(element, s)
+			if err != nil {
+				return err
+			}
+			if field.relation == selfRelation { // merged into the node, without overwriting its own keys
+				for key, value := range child {
+					if _, taken := node[key]; !taken {
+						node[key] = value
+					}
+				}
+				continue
+			}
+			node[field.relation] = child
+			if field.annotation != annotationInclude { // the single element of the array
+				node[field.relation] = []interface{}{child}
+			}
+		case annotationIncludes:
+			children := make([]interface{}, 0, fieldValue.Len())
+			for j := 0; j < fieldValue.Len(); j++ {
+				element, ok := relationStruct(fieldValue.Index(j))
+				if !ok {
+					continue
+				}
+				child, err := marshalChild(element, s)
+				if err != nil {
+					return err
+				}
+				children = append(children, child)
+			}
+			node[field.relation] = children
+		case annotationHasOneRelation:
+			element, ok := relationStruct(fieldValue)
+			if !ok {
+				continue
+			}
+			if field.backReference != "" { // hoisted by its own id, pointing back at the node
+				child, err := marshalSideloaded(element, fieldPlan{relation: field.relation}, s)
+				if err != nil {
+					return err
+				}
+				child[field.backReference] = node[field.lookupKey]
+				continue
+			}
+			if len(field.candidates) > 0 { // hoisted into the collection the discriminator of the node names
+				relation, _ := canonicalID(node[field.discriminator])
+				if !IsRelationshipInSlice(relation, field.candidates) {
+					return fmt.Errorf("%s %q of %s names none of %s", field.discriminator, relation, field.name, field.relation)
+				}
+				field.relation = relation
+			}
+			child, err := marshalSideloaded(element, field, s)
+			if err != nil {
+				return err
+			}
+			setAtPath(node, field.idPath, child[field.lookupKeyOr(s.opts.idField)])
+			setScope(node, field, child)
+		case annotationHasManyRelation:
+			ids := make([]interface{}, 0, fieldValue.Len())
+			for _, relationValue := range relationValues(fieldValue) {
+				element, ok := relationStruct(relationValue)
+				if !ok {
+					continue
+				}
+				child, err := marshalSideloaded(element, field, s)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, child[field.lookupKeyOr(s.opts.idField)])
+				setScope(node, field, child)
+			}
+			setAtPath(node, field.idPath, ids)
+		case annotationFlatten:
+			nested, ok := node[field.relation].(map[string]interface{})
+			if !ok {
+				nested = make(map[string]interface{})
+				node[field.relation] = nested
+			}
+			nested[field.flattenField.name] = fieldValue.Interface()
+		case annotationRaw:
+			var raws []json.RawMessage
+			isArray := fieldValue.Type() != rawMessageType
+			if isArray {
+				raws = fieldValue.Convert(reflect.TypeOf(raws)).Interface().([]json.RawMessage)
+			} else {
+				raws = []json.RawMessage{fieldValue.Interface().(json.RawMessage)}
+			}
+			if field.idField == "" {
+				if len(raws) > 0 && len(raws[0]) > 0 {
+					node[field.relation] = fieldValue.Interface()
+				}
+				continue
+			}
+			ids := make([]interface{}, 0, len(raws))
+			for _, raw := range raws {
+				if len(raw) == 0 {
+					continue
+				}
+				id, err := marshalRaw(raw, field, s)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, id)
+			}
+			if isArray {
+				setAtPath(node, field.idPath, ids)
+			} else if len(ids) > 0 {
+				setAtPath(node, field.idPath, ids[0])
+			}
+		case annotationZip: // split back into the parallel arrays, by position
+			arrays := make([][]interface{}, len(field.zipped))
+			for _, relationValue := range relationValues(fieldValue) {
+				element, ok := relationStruct(relationValue)
+				if !ok {
+					continue
+				}
+				child, err := marshalChild(element, s)
+				if err != nil {
+					return err
+				}
+				for i, key := range field.zipped {
+					arrays[i] = append(arrays[i], child[key])
+				}
+			}
+			for i, key := range field.zipped {
+				node[key] = arrays[i]
+			}
+		case annotationRest: // written back under their keys, unless another field took them
+			iter := fieldValue.MapRange()
+			for iter.Next() {
+				if _, taken := node[iter.Key().String()]; !taken {
+					node[iter.Key().String()] = iter.Value().Interface()
+				}
+			}
+		case annotationHasManyReverse:
+			parentID := node[field.lookupKeyOr(s.opts.idField)]
+			for _, relationValue := range relationValues(fieldValue) {
+				element, ok := relationStruct(relationValue)
+				if !ok {
+					continue
+				}
+				// the children are hoisted by their own id, pointing back at the node
+				child, err := marshalSideloaded(element, fieldPlan{relation: field.relation}, s)
+				if err != nil {
+					return err
+				}
+				child[field.idField] = parentID
+			}
+		}
+	}
+	return nil
+}
+
+// lookupField - the field of the struct at index, false when an embedded pointer on the way is nil
+func lookupField(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// zeroField - zeroes the field of the struct copy at index, copying the embedded structs pointed to on the way
+// so that the original is left untouched
+func zeroField(v reflect.Value, index []int) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() || !v.CanSet() {
+				return
+			}
+			embedded := reflect.New(v.Type().Elem())
+			embedded.Elem().Set(v.Elem())
+			v.Set(embedded)
+			v = embedded.Elem()
+		}
+		v = v.Field(x)
+	}
+	if v.CanSet() {
+		v.Set(reflect.Zero(v.Type()))
+	}
+}
+
+// setAtPath - sets the value under the keys of path, creating the nested objects on the way
+func setAtPath(node map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[key] = child
+		}
+		node = child
+	}
+	node[path[len(path)-1]] = value
+}
+
+// relationValues - the elements of a relation slice, or the values of a relation map in key order
+func relationValues(value reflect.Value) []reflect.Value {
+	if value.Kind() != reflect.Map {
+		values := make([]reflect.Value, value.Len())
+		for i := range values {
+			values[i] = value.Index(i)
+		}
+		return values
+	}
+	keys := value.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	values := make([]reflect.Value, len(keys))
+	for i, key := range keys {
+		values[i] = value.MapIndex(key)
+	}
+	return values
+}
+
+// relationStruct - the struct a relation value holds, false for nil pointers and interfaces
+func relationStruct(value reflect.Value) (reflect.Value, bool) {
+	if value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return value, false
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Ptr {
+		return value, true
+	}
+	if value.IsNil() {
+		return value, false
+	}
+	return value.Elem(), true
+}
+
+// marshalChild - marshals a nested (included) struct
+func marshalChild(value reflect.Value, s *sideloads) (map[string]interface{}, error) {
+	if !s.enter(value) {
+		return nil, fmt.Errorf("include cycle: %s is included in itself", typeName(value.Type()))
+	}
+	defer s.leave(value)
+	child, err := marshalPrimitives(value, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	return child, marshalNode(value, child, s)
+}
+
+// marshalSideloaded - hoists the struct into the relation's collection and returns its marshaled object
+func marshalSideloaded(value reflect.Value, field fieldPlan, s *sideloads) (map[string]interface{}, error) {
+	child, err := marshalPrimitives(value, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	lookupKey := field.indexLookupKey(s.opts.idField)
+	id := lookupID(child, lookupKey)
+	if id == nil {
+		return nil, fmt.Errorf("missing %s on sideloaded %s", lookupKey, field.relation)
+	}
+	// relations of an already hoisted object are not walked again, which also ends reference cycles
+	child, added := s.add(field.relation, id, child)
+	if added {
+		// a sideloaded object starts its own include path, its references not being walked twice
+		including := s.including
+		s.including = map[includedStruct]bool{}
+		s.enter(value)
+		err := marshalNode(value, child, s)
+		s.including = including
+		if err != nil {
+			return nil, err
+		}
+	}
+	return child, nil
+}
+
+// marshalRaw - hoists the raw object of a raw relation into s, returning its id
+func marshalRaw(raw json.RawMessage, field fieldPlan, s *sideloads) (interface{}, error) {
+	source, err := decodeSourceJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("malformed raw JSON for %s: %w", field.name, err)
+	}
+	child, ok := source.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expecting a raw object for %s, got %T", field.name, source)
+	}
+	lookupKey := field.indexLookupKey(s.opts.idField)
+	id := lookupID(child, lookupKey)
+	if id == nil {
+		return nil, fmt.Errorf("missing %s on sideloaded %s", lookupKey, field.relation)
+	}
+	s.add(field.relation, id, child)
+	return id, nil
+}
+
+// setScope - writes the scope fields of a compound id from the sideloaded object into node
+func setScope(node map[string]interface{}, field fieldPlan, child map[string]interface{}) {
+	for i, scopeField := range field.scopeFields {
+		node[scopeField] = child[field.scopeLookupKeys[i]]
+	}
+}
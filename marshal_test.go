@@ -0,0 +1,163 @@
+package jsonsideload
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshal(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "plan": "gold", "account_id": "u_123", "manager_ids": ["u_456", "u_123"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	assert.Nil(t, Unmarshal(data, resp))
+	out, err := Marshal(resp)
+	assert.Nil(t, err)
+
+	var payload map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out, &payload))
+	subscription := payload["subscriptions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "u_123", subscription["account_id"])
+	assert.Equal(t, []interface{}{"u_456", "u_123"}, subscription["manager_ids"])
+	assert.NotContains(t, subscription, "account")
+	assert.NotContains(t, subscription, "managers")
+	assert.Len(t, payload["accounts"], 2)
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	data, err := prepareTestData()
+	assert.Nil(t, err)
+	personResp := new(PersonResponse)
+	assert.Nil(t, Unmarshal(data, personResp))
+
+	out, err := Marshal(personResp)
+	assert.Nil(t, err)
+	roundTripped := new(PersonResponse)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, personResp, roundTripped)
+}
+
+func TestMarshalNestedIDField(t *testing.T) {
+	ticket := &Ticket{ID: 1, Account: &Account{ID: "u_456"}, Watchers: []Account{{ID: "u_123"}}}
+	out, err := Marshal(ticket)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"links": {"account": "u_456", "watchers": ["u_123"]},
+		"accounts": [{"id": "u_456", "name": ""}, {"id": "u_123", "name": ""}]
+	}`, string(out))
+}
+
+func TestMarshalHasManyReverse(t *testing.T) {
+	cart := &Cart{ID: 1, LineItems: []*LineItem{{ID: 10, Quantity: 2}, {ID: 11, Quantity: 5}}}
+	out, err := Marshal(cart)
+	assert.Nil(t, err)
+
+	roundTripped := new(Cart)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	if assert.Len(t, roundTripped.LineItems, 2) {
+		assert.Equal(t, float64(11), roundTripped.LineItems[1].ID)
+		assert.Equal(t, float64(1), roundTripped.LineItems[1].CartID)
+	}
+}
+
+func TestMarshalHasOneBackReference(t *testing.T) {
+	out, err := Marshal(&Purchase{ID: 1, Account: &Account{ID: "u_1", Name: "Acme"}})
+	assert.Nil(t, err)
+
+	var payload map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out, &payload))
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": "u_1", "name": "Acme", "order_id": float64(1)}}, payload["accounts"])
+	assert.NotContains(t, payload, "")
+
+	roundTripped := new(Purchase)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, "Acme", roundTripped.Account.Name)
+}
+
+func TestMarshalFlatten(t *testing.T) {
+	out, err := Marshal(&Page{Items: []*Tag{{Name: "a"}}, Total: 10, Page: 2})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"items": [{"name": "a"}], "meta": {"total": 10, "page": 2}}`, string(out))
+}
+
+func TestMarshalRaw(t *testing.T) {
+	out, err := Marshal(&Envelope{
+		ID:       1,
+		Account:  json.RawMessage(`{"id": "u_1", "name": "Acme"}`),
+		Watchers: []json.RawMessage{json.RawMessage(`{"id": "u_2"}`), json.RawMessage(`{"id": "u_1", "name": "Acme"}`)},
+		Metadata: json.RawMessage(`{"source": "import"}`),
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"account_id": "u_1",
+		"watcher_ids": ["u_2", "u_1"],
+		"metadata": {"source": "import"},
+		"accounts": [{"id": "u_1", "name": "Acme"}, {"id": "u_2"}]
+	}`, string(out))
+}
+
+func TestMarshalCandidateCollections(t *testing.T) {
+	out, err := Marshal(&Asset{ID: 1, OwnerType: "organizations", Owner: &Party{ID: 7, Name: "Acme"}})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"owner_type": "organizations",
+		"owner_id": 7,
+		"organizations": [{"id": 7, "name": "Acme"}]
+	}`, string(out))
+
+	_, err = Marshal(&Asset{ID: 1, OwnerType: "teams", Owner: &Party{ID: 7}})
+	assert.EqualError(t, err, `owner_type "teams" of Owner names none of users|organizations`)
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	out, err := Marshal(&Bundle{ID: 1, Watchers: Tags{}})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"id": 1, "labels": []}`, string(out))
+
+	bundle := &Bundle{ID: 1, Topics: []*Tag{{Name: "a"}}, Owner: &Account{ID: "u_1"}, Watchers: Tags{{Name: "b"}}}
+	out, err = Marshal(bundle)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"topics": [{"name": "a"}],
+		"owner_id": "u_1",
+		"watcher_ids": ["b"],
+		"labels": [],
+		"accounts": [{"id": "u_1", "name": ""}],
+		"tags": [{"name": "b"}]
+	}`, string(out))
+
+	roundTripped := new(Bundle)
+	assert.Nil(t, Unmarshal(out, roundTripped))
+	assert.Equal(t, "u_1", roundTripped.Owner.ID)
+	assert.Equal(t, Tags{{Name: "b"}}, roundTripped.Watchers)
+}
+
+func TestMarshalIncludeCycle(t *testing.T) {
+	folder := &Folder{Name: "root"}
+	folder.Parent = folder
+	_, err := Marshal(folder)
+	assert.EqualError(t, err, "include cycle: Folder is included in itself")
+
+	other := &Folder{Name: "other", Parent: folder}
+	folder.Parent = other
+	_, err = Marshal(other)
+	assert.EqualError(t, err, "include cycle: Folder is included in itself")
+
+	mirror := &Mirror{ID: 1}
+	mirror.Self = mirror
+	_, err = Marshal(mirror)
+	assert.EqualError(t, err, "include cycle: Mirror is included in itself")
+
+	// the same struct included twice off the path is no cycle
+	total := &Money{Cents: 1250, Currency: "USD"}
+	out, err := Marshal(&Invoice{ID: 1, Total: total, Payments: []*Money{total, total}})
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"payments":[`)
+}
@@ -2,6 +2,8 @@ package jsonsideload
 
 import (
 	"encoding/json"
+	"fmt"
+	"math/big"
 	"time"
 
 	mytime "github.com/vickyramachandra/time"
@@ -24,3 +26,621 @@ type City struct {
 	ID   float64 `json:"id"`
 	Name string  `json:"name"`
 }
+
+type SubscriptionResponse struct {
+	Subscriptions []*Subscription `json:"subscriptions" jsonsideload:"includes,subscriptions"`
+}
+
+type Subscription struct {
+	ID       string     `json:"id"`
+	Plan     string     `json:"plan"`
+	Account  *Account   `json:"account" jsonsideload:"hasone,accounts,account_id"`
+	Managers []*Account `json:"managers" jsonsideload:"hasmany,accounts,manager_ids"`
+}
+
+type Account struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Device struct {
+	ID     float64  `json:"id"`
+	Name   string   `json:"name"`
+	Owner  *Owner   `json:"owner" jsonsideload:"hasone,owners,owner_uuid,uuid"`
+	Owners []*Owner `json:"owners" jsonsideload:"hasmany,owners,owner_uuids,uuid"`
+}
+
+type Owner struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+type MissingHasOneIDField struct {
+	Account *Account `jsonsideload:"hasone,accounts"`
+}
+
+type MissingHasManyIDField struct {
+	Accounts []*Account `jsonsideload:"hasmany,accounts"`
+}
+
+type Order struct {
+	ID       float64   `json:"id"`
+	Customer *Customer `json:"customer" jsonsideload:"hasone,customers,customer_id"`
+}
+
+type Customer struct {
+	ID     float64  `json:"id"`
+	Name   string   `json:"name"`
+	Orders []*Order `json:"orders" jsonsideload:"hasmany,orders,order_ids"`
+}
+
+type Gadget struct {
+	Name  string `json:"name"`
+	Owner *Owner `json:"owner" jsonsideload:"hasone,owners,owner_uuid"`
+}
+
+type Post struct {
+	ID     int64   `json:"id"`
+	Author *Author `json:"author" jsonsideload:"hasone,authors,author_id"`
+}
+
+type Author struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type Invoice struct {
+	ID       float64  `json:"id"`
+	Total    *Money   `json:"total" jsonsideload:"include,total"`
+	Payments []*Money `json:"payments" jsonsideload:"includes,payments"`
+	Refund   *Money   `json:"refund" jsonsideload:"hasone,refunds,refund_id"`
+}
+
+// Money - an amount in cents, encoded as "12.50 USD" or as {"amount": "12.50 USD"}
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var amount string
+	if err := json.Unmarshal(data, &amount); err != nil {
+		var object struct {
+			Amount string `json:"amount"`
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		amount = object.Amount
+	}
+	var units, cents int64
+	if _, err := fmt.Sscanf(amount, "%d.%d %s", &units, &cents, &m.Currency); err != nil {
+		return err
+	}
+	m.Cents = units*100 + cents
+	return nil
+}
+
+type Article struct {
+	ID       float64   `json:"id"`
+	Tags     []Tag     `json:"tags" jsonsideload:"includes,tags"`
+	Keywords []Keyword `json:"keywords" jsonsideload:"hasmany,keywords,keyword_ids"`
+	Related  []*Tag    `json:"related" jsonsideload:"includes,related"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type Keyword struct {
+	ID    float64 `json:"id"`
+	Value string  `json:"value"`
+}
+
+type Shipment struct {
+	ID          float64 `json:"id"`
+	Address     Address `json:"address" jsonsideload:"include,address"`
+	Carrier     Carrier `json:"carrier" jsonsideload:"hasone,carriers,carrier_id"`
+	BackupRoute *Route  `json:"backup_route" jsonsideload:"include,backup_route"`
+}
+
+type Address struct {
+	Street string `json:"street"`
+	City   string `json:"city"`
+}
+
+type Carrier struct {
+	ID   float64 `json:"id"`
+	Name string  `json:"name"`
+}
+
+type Route struct {
+	Hops int `json:"hops"`
+}
+
+type BadInclude struct {
+	Count *int `json:"-" jsonsideload:"include,count"`
+}
+
+type Gallery struct {
+	Photos []*Photo `json:"-" jsonsideload:"includes,photos"`
+}
+
+type Photo struct {
+	URL string `json:"url"`
+}
+
+type Ticket struct {
+	ID       float64   `json:"id"`
+	Account  *Account  `json:"account" jsonsideload:"hasone,accounts,links.account"`
+	Watchers []Account `json:"watchers" jsonsideload:"hasmany,accounts,links.watchers"`
+}
+
+type CategoryResponse struct {
+	Root *Category `json:"root" jsonsideload:"hasone,categories,root_id"`
+}
+
+type Category struct {
+	ID       float64     `json:"id"`
+	Name     string      `json:"name"`
+	Parent   *Category   `json:"parent" jsonsideload:"hasone,categories,parent_id"`
+	Children []*Category `json:"children" jsonsideload:"hasmany,categories,children_ids"`
+}
+
+type Profile struct {
+	Avatar    *Photo     `json:"-" jsonsideload:"include,avatar"`
+	Photos    []*Photo   `json:"-" jsonsideload:"includes,photos"`
+	Account   *Account   `json:"-" jsonsideload:"hasone,accounts,account_id"`
+	Followers []*Account `json:"-" jsonsideload:"hasmany,accounts,follower_ids"`
+}
+
+type Comment struct {
+	ID      float64    `json:"id"`
+	Author  *Account   `json:"author" jsonsideload:"hasone,included.accounts,author_id"`
+	Likers  []*Account `json:"likers" jsonsideload:"hasmany,included.accounts,liker_ids"`
+	Flagger *Account   `json:"flagger" jsonsideload:"hasone,accounts,flagger_id"`
+}
+
+type Team struct {
+	ID       float64             `json:"id"`
+	Members  map[string]*Account `json:"members" jsonsideload:"hasmany,accounts,member_ids"`
+	Sponsors map[string]Account  `json:"sponsors" jsonsideload:"hasmany,accounts,sponsor_ids"`
+}
+
+type BadMapKey struct {
+	Members map[int]*Account `json:"members" jsonsideload:"hasmany,accounts,member_ids"`
+}
+
+type Folder struct {
+	Name   string  `json:"name"`
+	Size   int     `json:"size"`
+	Parent *Folder `json:"parent" jsonsideload:"include,parent"`
+}
+
+type Audit struct {
+	CreatedBy string `json:"created_by"`
+	Version   int    `json:"version"`
+}
+
+type Note struct {
+	Audit
+	*Revision
+	ID     int64   `json:"id,string"`
+	Title  string  `json:"title"`
+	Score  float32 `json:"score"`
+	Hidden bool
+	secret string
+}
+
+type Revision struct {
+	Number  int `json:"number"`
+	Version int `json:"version"`
+}
+
+type Event struct {
+	ID        float64   `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Host      *Host     `json:"host" jsonsideload:"hasone,hosts,host_id"`
+}
+
+type Host struct {
+	ID        float64    `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type Membership struct {
+	ID       float64          `json:"id"`
+	TenantID string           `json:"tenant_id"`
+	Account  *TenantAccount   `json:"account" jsonsideload:"hasone,accounts,tenant_id+account_id"`
+	Admins   []*TenantAccount `json:"admins" jsonsideload:"hasmany,accounts,tenant_id+admin_ids"`
+	Owner    *TenantAccount   `json:"owner" jsonsideload:"hasone,accounts,tenant_id+owner_uuid,tenant_id+uuid"`
+}
+
+type TenantAccount struct {
+	ID       string `json:"id"`
+	UUID     string `json:"uuid"`
+	TenantID string `json:"tenant_id"`
+	Name     string `json:"name"`
+}
+
+type Seat struct {
+	Account *TenantAccount `json:"account" jsonsideload:"hasone,accounts,tenant_id+account_id"`
+}
+
+type BadCompoundKey struct {
+	Account *TenantAccount `json:"account" jsonsideload:"hasone,accounts,tenant_id+account_id,id"`
+}
+
+type BaseModel struct {
+	ID        float64  `json:"id"`
+	CreatedBy *Account `json:"created_by" jsonsideload:"hasone,accounts,created_by_id"`
+}
+
+type Audited struct {
+	UpdatedBy *Account `json:"updated_by" jsonsideload:"hasone,accounts,updated_by_id"`
+}
+
+type Document struct {
+	BaseModel
+	*Audited
+	Title  string     `json:"title"`
+	Owners []*Account `json:"owners" jsonsideload:"hasmany,accounts,owner_ids"`
+}
+
+type Draft struct {
+	BaseModel
+	CreatedBy *Account `json:"created_by" jsonsideload:"hasone,accounts,author_id"`
+}
+
+type Assignment struct {
+	ID          float64 `json:"id"`
+	AccountID   string
+	Account     *Account   `json:"account" jsonsideload:"hasone,accounts,account_id"`
+	ReviewerIDs []string   `json:"reviewerIds"`
+	Reviewers   []*Account `json:"reviewers" jsonsideload:"hasmany,accounts,reviewer_ids"`
+	OwnerID     string     `json:"owner_id"`
+	Owner       *Account   `json:"owner" jsonsideload:"hasone,accounts,owner_id"`
+}
+
+type Grid struct {
+	Rows []*Row `json:"rows" jsonsideload:"includes,rows"`
+}
+
+type Row struct {
+	Cells []*Cell  `json:"cells" jsonsideload:"includes,cells"`
+	Owner *Account `json:"owner" jsonsideload:"hasone,accounts,owner_id"`
+}
+
+type Cell struct {
+	Values []*CellValue `json:"values" jsonsideload:"includes,values"`
+}
+
+type CellValue struct {
+	V float64 `json:"v"`
+}
+
+type Misconfigured struct {
+	Account  *Account  `json:"account" jsonsideload:"hasone,accounts"`
+	Address  Address   `json:"address" jsonsideload:"includ,address"`
+	Partner  *Partner  `json:"partner" jsonsideload:"hasone,partners,partner_id"`
+	Accounts []Account `json:"accounts" jsonsideload:"hasmany,accounts,account_ids,id+uuid"`
+}
+
+type Partner struct {
+	ID    float64 `json:"id"`
+	Count int     `json:"count" jsonsideload:"hasone,counts,count_id"`
+}
+
+type Misspelled struct {
+	Account *Account `json:"account" jsonsideload:"hasOne,accounts,account_id"`
+}
+
+type Agency struct {
+	Partners []*Partner `json:"partners" jsonsideload:"includes,partners"`
+}
+
+type Payable interface {
+	Last4() string
+}
+
+type CreditCard struct {
+	ID     float64 `json:"id"`
+	Type   string  `json:"type"`
+	Number string  `json:"number"`
+}
+
+func (c *CreditCard) Last4() string {
+	return c.Number[len(c.Number)-4:]
+}
+
+type BankAccount struct {
+	ID   float64 `json:"id"`
+	Type string  `json:"type"`
+	IBAN string  `json:"iban"`
+}
+
+func (b *BankAccount) Last4() string {
+	return b.IBAN[len(b.IBAN)-4:]
+}
+
+type Checkout struct {
+	ID       float64       `json:"id"`
+	Payable  Payable       `json:"payable" jsonsideload:"hasone,payables,payable_id"`
+	Fallback interface{}   `json:"fallback" jsonsideload:"include,fallback"`
+	Options  []Payable     `json:"options" jsonsideload:"hasmany,payables,option_ids"`
+	Saved    []interface{} `json:"saved" jsonsideload:"includes,saved"`
+}
+
+type Cart struct {
+	ID        float64     `json:"id"`
+	LineItems []*LineItem `json:"line_items" jsonsideload:"hasmany_reverse,line_items,cart_id"`
+}
+
+type Tab struct {
+	LineItems []*LineItem `json:"line_items" jsonsideload:"hasmany_reverse,line_items,cart_id"`
+}
+
+type Till struct {
+	ID      float64     `json:"id"`
+	Singles []*LineItem `json:"singles" jsonsideload:"hasmany_reverse,line_items,cart_id;where=quantity=1"`
+}
+
+type LineItem struct {
+	ID       float64 `json:"id"`
+	CartID   float64 `json:"cart_id"`
+	Quantity int     `json:"quantity"`
+}
+
+type Story struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Author  *Writer   `json:"author" jsonsideload:"hasone,people,relationships.author"`
+	Remarks []*Remark `json:"comments" jsonsideload:"hasmany,comments,relationships.comments"`
+}
+
+type Writer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Remark struct {
+	ID     string  `json:"id"`
+	Body   string  `json:"body"`
+	Author *Writer `json:"author" jsonsideload:"hasone,people,relationships.author"`
+}
+
+type Purchase struct {
+	ID      float64  `json:"id"`
+	Account *Account `json:"account" jsonsideload:"hasone,accounts,,order_id=id"`
+}
+
+type Booking struct {
+	Item *LineItem `json:"item" jsonsideload:"hasone,line_items,,cart_id=id"`
+}
+
+type Reservation struct {
+	ID      float64  `json:"id"`
+	Account *Account `json:"account" jsonsideload:"hasone,accounts,account"`
+}
+
+type Basket struct {
+	ID      float64     `json:"id"`
+	Items   []*LineItem `json:"items" jsonsideload:"hasmany,lineItems,line_item_ids"`
+	Coupons []Tag       `json:"coupons" jsonsideload:"includes,couponCodes"`
+}
+
+type Shelf struct {
+	ID      float64    `json:"id"`
+	Owner   *Account   `json:"owner" jsonsideload:"hasone,accounts,owner_id"`
+	Readers []*Account `json:"readers" jsonsideload:"hasmany,accounts,reader_ids"`
+	Label   *Tag       `json:"label" jsonsideload:"include,label"`
+	loaded  []string
+}
+
+func (s *Shelf) SetLoaded(fields []string) {
+	s.loaded = fields
+}
+
+type Page struct {
+	Items []*Tag `json:"items" jsonsideload:"includes,items"`
+	Total int    `json:"total" jsonsideload:"flatten,meta"`
+	Page  int    `json:"page" jsonsideload:"flatten,meta"`
+}
+
+type Transfer struct {
+	ID     float64 `json:"id"`
+	Wallet *Wallet `json:"wallet" jsonsideload:"hasone,wallets,wallet_id"`
+}
+
+type Wallet struct {
+	ID           float64        `json:"id"`
+	Transactions []*Transaction `json:"transactions" jsonsideload:"hasmany,transactions,transaction_ids"`
+}
+
+type Transaction struct {
+	ID     float64 `json:"id"`
+	Amount int     `json:"amount"`
+}
+
+type Envelope struct {
+	ID        float64           `json:"id"`
+	Account   json.RawMessage   `json:"account" jsonsideload:"raw,accounts,account_id"`
+	Watchers  []json.RawMessage `json:"watchers" jsonsideload:"raw,accounts,watcher_ids"`
+	Metadata  json.RawMessage   `json:"metadata" jsonsideload:"raw,metadata"`
+	Revisions []json.RawMessage `json:"revisions" jsonsideload:"raw,revisions"`
+}
+
+type Asset struct {
+	ID        float64 `json:"id"`
+	OwnerType string  `json:"owner_type"`
+	Owner     *Party  `json:"owner" jsonsideload:"hasone,users|organizations,owner_id,owner_type"`
+}
+
+type Party struct {
+	ID   float64 `json:"id"`
+	Name string  `json:"name"`
+}
+
+type Tags []*Tag
+
+type AccountsByID map[string]*Account
+
+type RawMessages []json.RawMessage
+
+type Catalog struct {
+	Tags     Tags         `json:"tags" jsonsideload:"hasmany,tags,tag_ids,name"`
+	Featured Tags         `json:"featured" jsonsideload:"includes,featured"`
+	Owners   AccountsByID `json:"owners" jsonsideload:"hasmany,accounts,owner_ids"`
+	Notes    RawMessages  `json:"notes" jsonsideload:"raw,notes"`
+	TagCount int          `json:"tag_count" jsonsideload:"count,tag_ids"`
+	Owned    uint8        `json:"owned" jsonsideload:"count,owner_ids"`
+}
+
+type Member struct {
+	UserID    string
+	CreatedAt time.Time
+	HTTPScore int
+	Nickname  string   `json:"nick"`
+	Manager   *Account `jsonsideload:"hasone,accounts,manager_id"`
+}
+
+type Parcel struct {
+	ID          float64   `json:"id"`
+	HasShipment bool      `json:"has_shipment"`
+	Shipment    *Shipment `json:"shipment" jsonsideload:"hasone,shipments,shipment_id;if=has_shipment"`
+}
+
+type Contact struct {
+	Name    string   `json:"name"`
+	Primary *Address `json:"primary_address" jsonsideload:"include_first,addresses"`
+	Latest  *Address `json:"latest_address" jsonsideload:"include_last,addresses"`
+}
+
+type Deployment struct {
+	Env      string    `json:"env"`
+	Config   *Config   `json:"config" jsonsideload:"hasone,configs,env"`
+	Services []*Config `json:"services" jsonsideload:"hasmany,configs,env"`
+}
+
+type Config struct {
+	ID          float64 `json:"id"`
+	Environment string  `json:"environment"`
+	Name        string  `json:"name"`
+}
+
+type Bundle struct {
+	ID       float64  `json:"id"`
+	Topics   []*Tag   `json:"topics" jsonsideload:"includes,topics,omitempty"`
+	Owner    *Account `json:"owner" jsonsideload:"hasone,accounts,owner_id,omitempty"`
+	Watchers Tags     `json:"watchers" jsonsideload:"hasmany,tags,watcher_ids,name,omitempty"`
+	Labels   []*Tag   `json:"labels" jsonsideload:"includes,labels"`
+}
+
+type Listing struct {
+	ID     float64                `json:"id"`
+	Title  string                 `json:"title"`
+	Seller *Account               `json:"seller" jsonsideload:"hasone,accounts,seller_id"`
+	Extra  map[string]interface{} `json:"-" jsonsideload:"rest"`
+}
+
+type Shipping struct {
+	ID          float64     `json:"id"`
+	ActiveItems []*LineItem `json:"active_items" jsonsideload:"hasmany,line_items,item_ids;where=status=active"`
+	Gift        *LineItem   `json:"gift" jsonsideload:"hasone,line_items,gift_id;where=status=active;where=gift=true"`
+}
+
+type Polygon struct {
+	ID      float64    `json:"id"`
+	Corners [3]*Corner `json:"corners" jsonsideload:"hasmany,corners,corner_ids"`
+	Anchors [2]*Corner `json:"anchors" jsonsideload:"includes,anchors"`
+}
+
+type Corner struct {
+	ID float64 `json:"id"`
+	X  float64 `json:"x"`
+	Y  float64 `json:"y"`
+}
+
+type PriceList struct {
+	ID     float64       `json:"id"`
+	Prices []*PriceLabel `json:"-" jsonsideload:"zip,prices+labels"`
+}
+
+type PriceLabel struct {
+	Price float64 `json:"prices"`
+	Label string  `json:"labels"`
+}
+
+type Receipt struct {
+	ID    float64          `json:"id"`
+	Items []*OrderItemEdge `json:"items" jsonsideload:"includes,items"`
+}
+
+type OrderItemEdge struct {
+	Quantity int   `json:"quantity"`
+	Item     *Item `json:"-" jsonsideload:"hasone,catalog_items,id"`
+}
+
+type Item struct {
+	ID   float64 `json:"id"`
+	Name string  `json:"name"`
+}
+
+type Token struct {
+	ID      *big.Int `json:"id"`
+	OwnerID *big.Int `json:"ownerId"`
+	Owner   *Vault   `json:"owner" jsonsideload:"hasone,vaults,owner_id"`
+}
+
+type Vault struct {
+	ID      *big.Int   `json:"id"`
+	Balance *big.Float `json:"balance"`
+}
+
+type ResultPage[T any] struct {
+	Items []*T `json:"items" jsonsideload:"includes,items"`
+	Total int  `json:"total"`
+}
+
+type Lookup[K comparable, T any] struct {
+	Key   K  `json:"key"`
+	Value *T `json:"value" jsonsideload:"hasone,customers,value_id"`
+}
+
+type Release struct {
+	ID      float64         `json:"id"`
+	Title   string          `json:"title"`
+	Summary *ReleaseSummary `json:"-" jsonsideload:"include,."`
+}
+
+type ReleaseSummary struct {
+	Version string    `json:"version"`
+	Owner   *Customer `json:"owner" jsonsideload:"hasone,customers,owner_id"`
+}
+
+type Mirror struct {
+	ID   float64 `json:"id"`
+	Self *Mirror `json:"-" jsonsideload:"include,."`
+}
+
+type Consignment struct {
+	ID          float64      `json:"id"`
+	Destination *Destination `json:"destination" jsonsideload:"hasone,destinations,destination_id"`
+}
+
+type Destination struct {
+	ID    float64 `json:"id"`
+	Zip   string  `json:"zip"`
+	Floor int     `json:"floor"`
+	Suite *string `json:"suite"`
+}
+
+type Warehouse struct {
+	ID       float64   `json:"id"`
+	Address  Address   `json:"address" jsonsideload:"include,address"`
+	Bins     []*Item   `json:"bins" jsonsideload:"includes,bins"`
+	Manager  *Owner    `json:"manager" jsonsideload:"hasone,owners,tenant_id+manager_uuid,tenant+uuid"`
+	Carriers []Carrier `json:"carriers" jsonsideload:"hasmany,carriers,carrier_ids"`
+	Stock    int       `json:"stock" jsonsideload:"count,bins"`
+}
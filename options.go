@@ -0,0 +1,458 @@
+package jsonsideload
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Option - configures an Unmarshal or Marshal call, or every call of a Decoder or Encoder
+type Option func(*options)
+
+type options struct {
+	idField  string
+	maxDepth int
+	// maxRelationItems caps the elements of a single includes or hasmany relation, zero meaning no limit
+	maxRelationItems int
+	strictRelations  bool
+	// disallowUnknownFields rejects keys of a node that no struct field or relation reads
+	disallowUnknownFields bool
+	// timeLayout parses and formats string time.Time fields, empty for encoding/json's RFC 3339
+	timeLayout string
+	logger     func(event string, fields map[string]interface{})
+	// referenceIDs fills the fields named after the id fields of relations
+	referenceIDs bool
+	// defaultSlices sets includes fields without an array to empty slices
+	defaultSlices bool
+	// uniqueIDs fails decoding on sideloaded objects sharing an id
+	uniqueIDs bool
+	// uniqueHasOne fails decoding on a hasone id shared by several sideloaded objects
+	uniqueHasOne bool
+	// continueOnError leaves out the relation elements failing to decode rather than stopping at the first error
+	continueOnError bool
+	// tolerantFields keeps the objects with primitive fields of the wrong type, collecting the errors
+	tolerantFields bool
+	// rootKey is the key of the payload wrapping the whole document, empty for an unwrapped payload
+	rootKey string
+	// sideloadRoot is the dotted path of the object holding the sideloaded arrays, empty for the top level
+	sideloadRoot string
+	// keyMatcher finds the relation keys of tags not spelled as in the payload, nil for exact matches only
+	keyMatcher func(tagKey, jsonKey string) bool
+	// dedupeRelations skips the ids of a hasmany field already met in it
+	dedupeRelations bool
+	// commaSeparatedIDs reads hasmany ids given as a string like "1,2,3"
+	commaSeparatedIDs bool
+	// polymorphic holds the concrete types of relations decoded into interface fields, by relation
+	polymorphic map[string]polymorphicTypes
+	// arrayIDs is how hasone ids given as arrays are read
+	arrayIDs ArrayIDPolicy
+	// fieldNameMapper maps the Go names of fields without a json name to their json keys, nil for the Go names
+	fieldNameMapper func(string) string
+	// stats counts the relation lookups of every decode, nil when not wanted
+	stats *Stats
+	// matchers pick the sideloaded objects of a relation for a node instead of its ids, by relation
+	matchers map[string]func(parent, candidate map[string]interface{}) bool
+	// stores hold the collections, keyed by id, searched for the ids the document does not sideload
+	stores map[string]interface{}
+	// storesErr is the first store object, by collection and id, that could not be normalized, failing every decode
+	storesErr error
+	// preprocessors transform the sideloaded objects of a relation before they are decoded, by relation
+	preprocessors map[string]func(obj map[string]interface{}) map[string]interface{}
+	// inlineRelations decodes hasone fields without a sideloaded object from the object under their json key
+	inlineRelations bool
+	// looseScalars coerces between string and number JSON scalars to match the kind of primitive fields
+	looseScalars bool
+	// onlyRelations are the relations decoded, by json key or relation key of the tag, nil for all of them
+	onlyRelations map[string]bool
+	// skipRelations are the relations left undecoded, by json key or relation key of the tag
+	skipRelations map[string]bool
+	// resolvers fetch the objects of a relation that the document does not sideload, by relation
+	resolvers map[string]func(id interface{}) (map[string]interface{}, error)
+}
+
+// polymorphicTypes - the concrete types of a polymorphic relation by the value of its discriminator field
+type polymorphicTypes struct {
+	discriminatorField string
+	registry           map[string]reflect.Type
+}
+
+// newOptions - the defaults overridden by the given options
+func newOptions(opts []Option) *options {
+	o := &options{idField: defaultLookupKey}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithIDField - matches relation ids against the given key of sideloaded objects instead of "id".
+// A fourth argument in the jsonsideload tag still takes precedence.
+func WithIDField(idField string) Option {
+	return func(o *options) {
+		o.idField = idField
+	}
+}
+
+// WithMaxDepth - fails decoding when relations are nested deeper than depth, zero meaning no limit
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithMaxRelationItems - fails decoding when a single includes, hasmany or array raw relation has more than n elements,
+// zero meaning no limit. It bounds the work a hostile payload can cause.
+func WithMaxRelationItems(n int) Option {
+	return func(o *options) {
+		o.maxRelationItems = n
+	}
+}
+
+// WithStrictRelations - fails decoding when a hasone or hasmany id has no sideloaded object
+func WithStrictRelations(strict bool) Option {
+	return func(o *options) {
+		o.strictRelations = strict
+	}
+}
+
+// WithDisallowUnknownFields - fails decoding when an object has a key that is neither a field of its struct
+// nor read by one of its relations, like json.Decoder.DisallowUnknownFields
+func WithDisallowUnknownFields(disallow bool) Option {
+	return func(o *options) {
+		o.disallowUnknownFields = disallow
+	}
+}
+
+// WithTimeLayout - parses string time.Time and *time.Time fields with the layout instead of RFC 3339,
+// and formats them with it when marshaling
+func WithTimeLayout(layout string) Option {
+	return func(o *options) {
+		o.timeLayout = layout
+	}
+}
+
+const (
+	// EventRelationLookup - the event logged for every hasone or hasmany id looked up, with the fields
+	// relation, lookup_key, id, found and field
+	EventRelationLookup = "relation_lookup"
+	// EventDuplicateID - the event logged for every sideloaded object whose id an earlier object of the same
+	// array already has, with the fields relation, lookup_key and id
+	EventDuplicateID = "duplicate_id"
+)
+
+// WithLogger - calls logger for every relation looked up while decoding, to trace why relations stay unresolved
+func WithLogger(logger func(event string, fields map[string]interface{})) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithStores - searches the objects of stores, by collection and then by id, for the ids of a relation that the
+// document does not sideload, like a shared reference dataset fetched once. Objects in the document win.
+// Decodes fail with an object of the stores that cannot be read like a parsed payload, like a channel.
+func WithStores(stores map[string]map[string]interface{}) Option {
+	collections := make(map[string]interface{}, len(stores))
+	var storesErr error
+	var failedCollection, failedID string // the first failing object, whatever the order maps are ranged in
+	for collection, objects := range stores {
+		byID := make(map[string]interface{}, len(objects))
+		for id, object := range objects {
+			normalized, err := normalizeSource(object) // numbers read like those of the document
+			if err != nil {
+				if storesErr == nil || collection < failedCollection || collection == failedCollection && id < failedID {
+					storesErr = fmt.Errorf("malformed store object %s %s provided: %w", collection, id, err)
+					failedCollection, failedID = collection, id
+				}
+				continue
+			}
+			byID[id] = normalized
+		}
+		collections[collection] = byID
+	}
+	return func(o *options) {
+		o.stores = collections
+		o.storesErr = storesErr
+	}
+}
+
+// WithInlineRelations - decodes a hasone field whose id is missing or has no sideloaded object from the object
+// inlined under the key of its json tag instead, like "account" for an account_id, for endpoints inlining the
+// objects other endpoints sideload
+func WithInlineRelations(inline bool) Option {
+	return func(o *options) {
+		o.inlineRelations = inline
+	}
+}
+
+// WithOnlyRelations - decodes only the relations named, by the key of the json tag of their field or the relation
+// key of their tag, leaving the other relation fields untouched however much the payload holds for them
+func WithOnlyRelations(relations ...string) Option {
+	return func(o *options) {
+		if o.onlyRelations == nil {
+			o.onlyRelations = make(map[string]bool, len(relations))
+		}
+		for _, relation := range relations {
+			o.onlyRelations[relation] = true
+		}
+	}
+}
+
+// WithSkipRelations - leaves the relations named, by the key of the json tag of their field or the relation key of
+// their tag, untouched rather than decoding them
+func WithSkipRelations(relations ...string) Option {
+	return func(o *options) {
+		if o.skipRelations == nil {
+			o.skipRelations = make(map[string]bool, len(relations))
+		}
+		for _, relation := range relations {
+			o.skipRelations[relation] = true
+		}
+	}
+}
+
+// skipsRelation - reports whether the relation of the field is left out by WithOnlyRelations or WithSkipRelations
+func (o *options) skipsRelation(field fieldPlan) bool {
+	if !field.isRelation() {
+		return false
+	}
+	if o.onlyRelations != nil && !o.onlyRelations[field.jsonKey] && !o.onlyRelations[field.relation] {
+		return true
+	}
+	return o.skipRelations[field.jsonKey] || o.skipRelations[field.relation]
+}
+
+// WithResolver - looks up the relation's ids that have no sideloaded object with fn, decoding the object it
+// returns like a sideloaded one. A nil object leaves the relation unresolved and an error stops decoding.
+func WithResolver(relation string, fn func(id interface{}) (map[string]interface{}, error)) Option {
+	return func(o *options) {
+		if o.resolvers == nil {
+			o.resolvers = make(map[string]func(id interface{}) (map[string]interface{}, error))
+		}
+		o.resolvers[relation] = fn
+	}
+}
+
+// WithPreprocessor - decodes each sideloaded object of the relation as fn returns it, like with keys renamed or
+// defaults added. The object passed is the one of the payload, shared by every reference to it, so fn should return
+// a changed copy rather than change it. A nil object decodes the object as it is.
+func WithPreprocessor(relation string, fn func(obj map[string]interface{}) map[string]interface{}) Option {
+	return func(o *options) {
+		if o.preprocessors == nil {
+			o.preprocessors = make(map[string]func(obj map[string]interface{}) map[string]interface{})
+		}
+		o.preprocessors[relation] = fn
+	}
+}
+
+// WithMatcher - picks the sideloaded objects of the hasone or hasmany relation by fn rather than by id, fn being
+// called with the object holding the relation and each object of the sideloaded array. A hasone is set to the first
+// object accepted and a hasmany to all of them, in the order of the array.
+func WithMatcher(relation string, fn func(parent, candidate map[string]interface{}) bool) Option {
+	return func(o *options) {
+		if o.matchers == nil {
+			o.matchers = make(map[string]func(parent, candidate map[string]interface{}) bool)
+		}
+		o.matchers[relation] = fn
+	}
+}
+
+// WithReferenceIDs - also fills the field named after the id field of a hasone or hasmany relation, like
+// AccountID for account_id, with the ids when no field is keyed by the id field itself
+func WithReferenceIDs(fill bool) Option {
+	return func(o *options) {
+		o.referenceIDs = fill
+	}
+}
+
+// WithCommaSeparatedIDs - reads the ids of hasmany relations given as a comma separated string, like "1, 2,3",
+// trimming spaces and skipping empty ids
+func WithCommaSeparatedIDs(split bool) Option {
+	return func(o *options) {
+		o.commaSeparatedIDs = split
+	}
+}
+
+// WithPolymorphic - decodes the objects of the relation into interface typed fields, picking their type from
+// registry by the value of their discriminatorField. The fields are set to pointers to the registered structs.
+// Objects of unregistered types are skipped, or fail decoding with WithStrictRelations.
+func WithPolymorphic(relation string, discriminatorField string, registry map[string]reflect.Type) Option {
+	return func(o *options) {
+		if o.polymorphic == nil {
+			o.polymorphic = make(map[string]polymorphicTypes)
+		}
+		o.polymorphic[relation] = polymorphicTypes{discriminatorField: discriminatorField, registry: registry}
+	}
+}
+
+// WithDefaultSlices - sets includes fields to an empty slice rather than leaving them nil when the array is
+// missing or null, so that they encode as []. Hasmany fields are always set to a non nil slice.
+func WithDefaultSlices(enabled bool) Option {
+	return func(o *options) {
+		o.defaultSlices = enabled
+	}
+}
+
+// WithUniqueIDs - fails decoding with a *DuplicateIDError when a sideloaded array holds two objects with the same
+// id, rather than using the first of them
+func WithUniqueIDs(unique bool) Option {
+	return func(o *options) {
+		o.uniqueIDs = unique
+	}
+}
+
+// WithUniqueHasOne - fails decoding with a *DuplicateIDError when the id of a hasone matches several sideloaded
+// objects, rather than using the first of them. Duplicates no hasone looks up are left alone.
+func WithUniqueHasOne(unique bool) Option {
+	return func(o *options) {
+		o.uniqueHasOne = unique
+	}
+}
+
+// WithLooseScalarCoercion - decodes JSON numbers into string fields, and strings holding a number into numeric
+// fields, rather than failing on the mismatch. Fields with a custom decoding are left strict.
+func WithLooseScalarCoercion(loose bool) Option {
+	return func(o *options) {
+		o.looseScalars = loose
+	}
+}
+
+// WithContinueOnError - leaves out the includes, hasmany and top level array elements that fail to decode and
+// goes on with the rest, returning the decoded elements along with the errors of the others joined by errors.Join
+func WithContinueOnError(enabled bool) Option {
+	return func(o *options) {
+		o.continueOnError = enabled
+	}
+}
+
+// WithTolerantFields - keeps decoding objects past primitive fields holding a value of the wrong type, like a
+// string for an int, leaving those fields unset and returning their errors joined by errors.Join with the model
+func WithTolerantFields(tolerant bool) Option {
+	return func(o *options) {
+		o.tolerantFields = tolerant
+	}
+}
+
+// WithRootKey - decodes the document under the key of a wrapped payload, like "data" for {"data": {...}}, in
+// place of the whole payload, both as the model source and as the store of the sideloaded arrays.
+// A payload without the key is an error. JSON:API documents ignore it.
+func WithRootKey(key string) Option {
+	return func(o *options) {
+		o.rootKey = key
+	}
+}
+
+// WithSideloadRoot - looks the sideloaded arrays up in the object under the dotted path root, like "store" for
+// {"store": {"accounts": [...]}}, instead of at the top level of the payload. JSON:API documents ignore it.
+func WithSideloadRoot(root string) Option {
+	return func(o *options) {
+		o.sideloadRoot = root
+	}
+}
+
+// WithKeyMatcher - finds the include and includes keys and the sideloaded arrays of relations under a json key
+// the function accepts for the key of the tag, when the payload has none spelled exactly as in the tag
+func WithKeyMatcher(match func(tagKey, jsonKey string) bool) Option {
+	return func(o *options) {
+		o.keyMatcher = match
+	}
+}
+
+// MatchNormalizedKeys - a key matcher for WithKeyMatcher ignoring case and separators, so that lineItems,
+// LineItems and line_items match
+func MatchNormalizedKeys(tagKey, jsonKey string) bool {
+	return normalizeFieldName(tagKey) == normalizeFieldName(jsonKey)
+}
+
+// WithDedupeRelations - skips the ids a hasmany field repeats, like 1 in [1, 1, 2], so that each object appears
+// once in the slice, in the order of its first id. Repeats across fields are kept.
+func WithDedupeRelations(enabled bool) Option {
+	return func(o *options) {
+		o.dedupeRelations = enabled
+	}
+}
+
+// Stats - counts of the relation resolution work of decodes, filled by WithStats
+type Stats struct {
+	// Resolved is the number of relation objects found, sideloaded or by a resolver
+	Resolved int
+	// Misses is the number of relation ids without an object
+	Misses int
+	// CacheHits is the number of relation objects already decoded for an earlier reference to the same id
+	CacheHits int
+}
+
+// WithStats - adds the counts of the relations each decode resolves to s once the decode ends, so that s holds
+// the counts of a single call when given to it alone. The counts add up over the decodes of a Decoder, which may
+// run concurrently; read s once they are done.
+func WithStats(s *Stats) Option {
+	return func(o *options) {
+		o.stats = s
+	}
+}
+
+// statsMu guards the Stats of every decode, which the concurrent decodes of a Decoder add to
+var statsMu sync.Mutex
+
+// add - adds the counts of other to s
+func (s *Stats) add(other Stats) {
+	s.Resolved += other.Resolved
+	s.Misses += other.Misses
+	s.CacheHits += other.CacheHits
+}
+
+// addStats - adds the counts of a decode to the Stats of the options, if any
+func (o *options) addStats(counts *Stats) {
+	if o.stats == nil {
+		return
+	}
+	statsMu.Lock()
+	o.stats.add(*counts)
+	statsMu.Unlock()
+}
+
+// ArrayIDPolicy - how a hasone reads an id given as an array, like "account_id": [5]
+type ArrayIDPolicy int
+
+const (
+	// RejectArrayIDs - fails decoding on array ids, the default
+	RejectArrayIDs ArrayIDPolicy = iota
+	// SingleArrayIDs - reads the id of a one element array, failing decoding on longer ones
+	SingleArrayIDs
+	// FirstArrayID - reads the first id of the array
+	FirstArrayID
+)
+
+// WithArrayIDPolicy - reads hasone ids given as arrays by the policy instead of failing decoding on them.
+// Empty arrays leave the relation unset.
+func WithArrayIDPolicy(policy ArrayIDPolicy) Option {
+	return func(o *options) {
+		o.arrayIDs = policy
+	}
+}
+
+// WithFieldNameMapper - decodes the fields without a name in their json tag from the key mapper returns for their
+// Go name, like SnakeCase mapping CreatedAt to created_at, rather than from the key spelled like the Go name
+func WithFieldNameMapper(mapper func(fieldName string) string) Option {
+	return func(o *options) {
+		o.fieldNameMapper = mapper
+	}
+}
+
+// SnakeCase - a field name mapper for WithFieldNameMapper, mapping CreatedAt to created_at and UserID to user_id
+func SnakeCase(fieldName string) string {
+	runes := []rune(fieldName)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,728 @@
+package jsonsideload
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithIDField(t *testing.T) {
+	data := []byte(`{"name": "watch", "owner_uuid": "a3f2", "owners": [{"uuid": "a3f2", "name": "Ann"}]}`)
+	gadget := new(Gadget)
+	err := Unmarshal(data, gadget, WithIDField("uuid"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", gadget.Owner.Name)
+
+	out, err := Marshal(gadget, WithIDField("uuid"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"name": "watch", "owner_uuid": "a3f2", "owners": [{"uuid": "a3f2", "name": "Ann"}]}`, string(out))
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"customer_id": 7,
+		"orders": [{"id": 1, "customer_id": 7}],
+		"customers": [{"id": 7, "order_ids": [1]}]
+	}`)
+	err := Unmarshal(data, new(Order), WithMaxDepth(1))
+	assert.EqualError(t, err, "Order.Customer.Orders[0]: relations nested deeper than the maximum depth of 1")
+	err = Unmarshal(data, new(Order), WithMaxDepth(2))
+	assert.Nil(t, err)
+}
+
+func TestWithMaxRelationItems(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "manager_ids": ["u_1", "u_2", "u_3"]}],
+		"accounts": [{"id": "u_1"}, {"id": "u_2"}, {"id": "u_3"}]
+	}`)
+	err := Unmarshal(data, new(SubscriptionResponse), WithMaxRelationItems(2))
+	assert.EqualError(t, err, "SubscriptionResponse.Subscriptions[0].Managers: 3 relation items, more than the maximum of 2")
+	err = Unmarshal([]byte(`{"subscriptions": [{"id": "s_1"}, {"id": "s_2"}]}`), new(SubscriptionResponse), WithMaxRelationItems(1))
+	assert.EqualError(t, err, "SubscriptionResponse.Subscriptions: 2 relation items, more than the maximum of 1")
+	err = Unmarshal(data, new(SubscriptionResponse), WithMaxRelationItems(3))
+	assert.Nil(t, err)
+	// raw relations are bounded as well, inlined or sideloaded
+	raw := []byte(`{"watcher_ids": ["u_1", "u_2", "u_3"], "revisions": [{"n": 1}], "accounts": [{"id": "u_1"}, {"id": "u_2"}, {"id": "u_3"}]}`)
+	err = Unmarshal(raw, new(Envelope), WithMaxRelationItems(2))
+	assert.EqualError(t, err, "Envelope.Watchers: 3 relation items, more than the maximum of 2")
+	err = Unmarshal([]byte(`{"revisions": [{"n": 1}, {"n": 2}, {"n": 3}]}`), new(Envelope), WithMaxRelationItems(2))
+	assert.EqualError(t, err, "Envelope.Revisions: 3 relation items, more than the maximum of 2")
+	envelope := new(Envelope)
+	assert.Nil(t, Unmarshal(raw, envelope, WithMaxRelationItems(3)))
+	assert.Len(t, envelope.Watchers, 3)
+}
+
+func TestWithStrictRelations(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_404"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	err := Unmarshal(data, new(SubscriptionResponse))
+	assert.Nil(t, err)
+	err = Unmarshal(data, new(SubscriptionResponse), WithStrictRelations(true))
+	assert.EqualError(t, err, "no sideloaded accounts found with id u_404 for SubscriptionResponse.Subscriptions[0].Managers[0]")
+
+	var unresolved *UnresolvedRelationError
+	if assert.True(t, errors.As(err, &unresolved)) {
+		assert.Equal(t, "accounts", unresolved.Relation)
+		assert.Equal(t, "u_404", unresolved.ID)
+		assert.Equal(t, "SubscriptionResponse.Subscriptions[0].Managers[0]", unresolved.Field)
+	}
+}
+
+func TestWithDisallowUnknownFields(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_123"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp, WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", resp.Subscriptions[0].Account.Name)
+
+	data = []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123", "tier": "gold"}],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	err = Unmarshal(data, new(SubscriptionResponse))
+	assert.Nil(t, err)
+	err = Unmarshal(data, new(SubscriptionResponse), WithDisallowUnknownFields(true))
+	assert.EqualError(t, err, `unknown field "tier" in SubscriptionResponse.Subscriptions[0]`)
+
+	data = []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123"}],
+		"accounts": [{"id": "u_123", "name": "Acme", "region": "eu"}]
+	}`)
+	err = Unmarshal(data, new(SubscriptionResponse), WithDisallowUnknownFields(true))
+	assert.EqualError(t, err, `unknown field "region" in SubscriptionResponse.Subscriptions[0].Account`)
+}
+
+func TestWithTimeLayout(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"created_at": "2019-03-01 08:30:00",
+		"host_id": 7,
+		"hosts": [{"id": 7, "created_at": "2018-12-14 11:28:29", "updated_at": "2019-01-02 00:00:00"}]
+	}`)
+	err := Unmarshal(data, new(Event))
+	assert.NotNil(t, err)
+
+	event := new(Event)
+	err = Unmarshal(data, event, WithTimeLayout("2006-01-02 15:04:05"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2019, 3, 1, 8, 30, 0, 0, time.UTC), event.CreatedAt)
+	assert.Equal(t, time.Date(2018, 12, 14, 11, 28, 29, 0, time.UTC), event.Host.CreatedAt)
+	assert.Equal(t, time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC), *event.Host.UpdatedAt)
+
+	out, err := Marshal(event, WithTimeLayout("2006-01-02 15:04:05"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"created_at": "2019-03-01 08:30:00",
+		"host_id": 7,
+		"hosts": [{"id": 7, "created_at": "2018-12-14 11:28:29", "updated_at": "2019-01-02 00:00:00"}]
+	}`, string(out))
+
+	err = Unmarshal([]byte(`{"created_at": "2019-03-01T08:30:00Z"}`), new(Event), WithTimeLayout("2006-01-02 15:04:05"))
+	assert.NotNil(t, err)
+}
+
+func TestWithLogger(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_404"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	var events []map[string]interface{}
+	err := Unmarshal(data, new(SubscriptionResponse), WithLogger(func(event string, fields map[string]interface{}) {
+		assert.Equal(t, EventRelationLookup, event)
+		events = append(events, fields)
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"relation": "accounts", "lookup_key": "id", "id": "u_123", "found": true, "field": "SubscriptionResponse.Subscriptions[0].Account"},
+		{"relation": "accounts", "lookup_key": "id", "id": "u_404", "found": false, "field": "SubscriptionResponse.Subscriptions[0].Managers[0]"},
+	}, events)
+}
+
+func TestWithResolver(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [
+			{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_456", "u_404"]},
+			{"id": "s_2", "account_id": "u_456"}
+		],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	var calls []interface{}
+	resolver := func(id interface{}) (map[string]interface{}, error) {
+		calls = append(calls, id)
+		if id == "u_456" {
+			return map[string]interface{}{"id": "u_456", "name": "Globex"}, nil
+		}
+		return nil, nil
+	}
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp, WithResolver("accounts", resolver))
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", resp.Subscriptions[0].Account.Name)
+	assert.Len(t, resp.Subscriptions[0].Managers, 1)
+	assert.Equal(t, "Globex", resp.Subscriptions[0].Managers[0].Name)
+	assert.True(t, resp.Subscriptions[0].Managers[0] == resp.Subscriptions[1].Account)
+	assert.Equal(t, []interface{}{"u_456", "u_404"}, calls)
+
+	errUnavailable := errors.New("cache unavailable")
+	err = Unmarshal(data, new(SubscriptionResponse), WithResolver("accounts", func(id interface{}) (map[string]interface{}, error) {
+		return nil, errUnavailable
+	}))
+	assert.EqualError(t, err, "resolving accounts u_456 for SubscriptionResponse.Subscriptions[0].Managers[0]: cache unavailable")
+	assert.True(t, errors.Is(err, errUnavailable))
+}
+
+func TestWithReferenceIDs(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"account_id": "u_123",
+		"reviewer_ids": ["u_123", "u_404"],
+		"owner_id": "u_456",
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	assignment := new(Assignment)
+	err := Unmarshal(data, assignment)
+	assert.Nil(t, err)
+	assert.Equal(t, "", assignment.AccountID)
+	assert.Nil(t, assignment.ReviewerIDs)
+
+	assignment = new(Assignment)
+	err = Unmarshal(data, assignment, WithReferenceIDs(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "u_123", assignment.AccountID)
+	assert.Equal(t, "Acme", assignment.Account.Name)
+	assert.Equal(t, []string{"u_123", "u_404"}, assignment.ReviewerIDs)
+	assert.Len(t, assignment.Reviewers, 1)
+	assert.Equal(t, "u_456", assignment.OwnerID)
+	assert.Equal(t, "Globex", assignment.Owner.Name)
+}
+
+func TestWithCommaSeparatedIDs(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "manager_ids": " u_123, ,u_456,"}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	assert.Nil(t, Unmarshal(data, resp))
+	assert.Empty(t, resp.Subscriptions[0].Managers)
+
+	resp = new(SubscriptionResponse)
+	assert.Nil(t, Unmarshal(data, resp, WithCommaSeparatedIDs(true)))
+	assert.Len(t, resp.Subscriptions[0].Managers, 2)
+	assert.Equal(t, "Acme", resp.Subscriptions[0].Managers[0].Name)
+	assert.Equal(t, "Globex", resp.Subscriptions[0].Managers[1].Name)
+
+	order := new(Order)
+	err := Unmarshal([]byte(`{"id": 1, "customer_id": 7, "orders": [{"id": 1, "customer_id": 7}], "customers": [{"id": 7, "order_ids": "1"}]}`), order, WithCommaSeparatedIDs(true))
+	assert.Nil(t, err)
+	assert.Len(t, order.Customer.Orders, 1)
+	assert.True(t, order.Customer.Orders[0].Customer == order.Customer)
+}
+
+func TestWithPolymorphic(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"payable_id": 2,
+		"option_ids": [1, 2, 3],
+		"fallback": {"id": 9, "type": "bank_account", "iban": "DE89370400440532013000"},
+		"saved": [{"id": 5, "type": "credit_card", "number": "4111111111111111"}, {"id": 6, "type": "voucher"}],
+		"payables": [
+			{"id": 1, "type": "credit_card", "number": "4242424242424242"},
+			{"id": 2, "type": "bank_account", "iban": "GB29NWBK60161331926819"},
+			{"id": 3, "type": "voucher"}
+		]
+	}`)
+	registry := map[string]reflect.Type{
+		"credit_card":  reflect.TypeOf(CreditCard{}),
+		"bank_account": reflect.TypeOf((*BankAccount)(nil)),
+	}
+	opts := []Option{
+		WithPolymorphic("payables", "type", registry),
+		WithPolymorphic("fallback", "type", registry),
+		WithPolymorphic("saved", "type", registry),
+	}
+	checkout := new(Checkout)
+	err := Unmarshal(data, checkout, opts...)
+	assert.Nil(t, err)
+	assert.IsType(t, &BankAccount{}, checkout.Payable)
+	assert.Equal(t, "6819", checkout.Payable.Last4())
+	assert.Len(t, checkout.Options, 2)
+	assert.Equal(t, "4242", checkout.Options[0].Last4())
+	assert.True(t, checkout.Options[1] == checkout.Payable)
+	assert.Equal(t, &BankAccount{ID: 9, Type: "bank_account", IBAN: "DE89370400440532013000"}, checkout.Fallback)
+	assert.Equal(t, []interface{}{&CreditCard{ID: 5, Type: "credit_card", Number: "4111111111111111"}}, checkout.Saved)
+
+	out, err := Marshal(checkout)
+	assert.Nil(t, err)
+	roundTripped := new(Checkout)
+	assert.Nil(t, Unmarshal(out, roundTripped, opts...))
+	assert.Equal(t, checkout, roundTripped)
+
+	err = Unmarshal(data, new(Checkout), append(opts, WithStrictRelations(true))...)
+	assert.EqualError(t, err, `Checkout.Options[2]: unknown type "voucher" for relation payables`)
+	err = Unmarshal(data, new(Checkout))
+	assert.EqualError(t, err, "Checkout.Payable: no polymorphic types registered for relation payables")
+}
+
+func TestWithDefaultSlices(t *testing.T) {
+	for _, data := range []string{`{}`, `{"photos": null}`} {
+		profile := new(Profile)
+		assert.Nil(t, Unmarshal([]byte(data), profile))
+		assert.Nil(t, profile.Photos)
+
+		profile = new(Profile)
+		assert.Nil(t, Unmarshal([]byte(data), profile, WithDefaultSlices(true)))
+		assert.Equal(t, []*Photo{}, profile.Photos)
+		assert.Equal(t, []*Account{}, profile.Followers)
+		assert.Nil(t, profile.Avatar)
+	}
+
+	grid := new(Grid)
+	assert.Nil(t, Unmarshal([]byte(`{"rows": [{"cells": [{}]}]}`), grid, WithDefaultSlices(true)))
+	out, err := json.Marshal(grid)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"rows": [{"cells": [{"values": []}], "owner": null}]}`, string(out))
+}
+
+func TestWithUniqueIDs(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123"}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456"}, {"id": "u_123", "name": "Acme again"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	var duplicates []map[string]interface{}
+	err := Unmarshal(data, resp, WithLogger(func(event string, fields map[string]interface{}) {
+		if event == EventDuplicateID {
+			duplicates = append(duplicates, fields)
+		}
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", resp.Subscriptions[0].Account.Name)
+	assert.Equal(t, []map[string]interface{}{{"relation": "accounts", "lookup_key": "id", "id": "u_123"}}, duplicates)
+
+	err = Unmarshal(data, new(SubscriptionResponse), WithUniqueIDs(true))
+	assert.EqualError(t, err, "duplicate sideloaded accounts with id u_123")
+	var duplicate *DuplicateIDError
+	assert.True(t, errors.As(err, &duplicate))
+
+	// every lookup of the relation fails, not only the one building its index
+	data = []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123"}, {"id": "s_2", "manager_ids": ["u_123"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_123", "name": "Acme again"}]
+	}`)
+	resp = new(SubscriptionResponse)
+	err = Unmarshal(data, resp, WithUniqueIDs(true), WithContinueOnError(true))
+	assert.EqualError(t, err, "duplicate sideloaded accounts with id u_123\nduplicate sideloaded accounts with id u_123")
+	if assert.Len(t, resp.Subscriptions, 1) {
+		assert.Equal(t, "s_2", resp.Subscriptions[0].ID)
+		assert.Empty(t, resp.Subscriptions[0].Managers)
+	}
+}
+
+func TestWithUniqueHasOne(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_456"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456"}, {"id": "u_123", "name": "Acme again"}]
+	}`)
+	err := Unmarshal(data, new(SubscriptionResponse), WithUniqueHasOne(true))
+	assert.EqualError(t, err, "SubscriptionResponse.Subscriptions[0].Account: duplicate sideloaded accounts with id u_123")
+	var duplicate *DuplicateIDError
+	if assert.True(t, errors.As(err, &duplicate)) {
+		assert.Equal(t, "accounts", duplicate.Relation)
+		assert.Equal(t, "u_123", duplicate.ID)
+	}
+
+	// the duplicates of ids only a hasmany looks up are not ambiguous hasone matches
+	resp := new(SubscriptionResponse)
+	err = Unmarshal([]byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_456", "manager_ids": ["u_123"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456"}, {"id": "u_123", "name": "Acme again"}]
+	}`), resp, WithUniqueHasOne(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", resp.Subscriptions[0].Managers[0].Name)
+}
+
+func TestWithKeyMatcher(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"line_item_ids": [10],
+		"coupon_codes": [{"name": "SPRING"}],
+		"line_items": [{"id": 10, "quantity": 2}]
+	}`)
+	basket := new(Basket)
+	err := Unmarshal(data, basket)
+	assert.Nil(t, err)
+	assert.Empty(t, basket.Items)
+	assert.Nil(t, basket.Coupons)
+
+	basket = new(Basket)
+	err = Unmarshal(data, basket, WithKeyMatcher(MatchNormalizedKeys), WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	if assert.Len(t, basket.Items, 1) {
+		assert.Equal(t, 2, basket.Items[0].Quantity)
+	}
+	assert.Equal(t, []Tag{{Name: "SPRING"}}, basket.Coupons)
+}
+
+func TestWithDedupeRelations(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_2", "manager_ids": ["u_1", "u_1", "u_2", 1, "1"]}],
+		"accounts": [{"id": "u_1", "name": "Acme"}, {"id": "u_2", "name": "Globex"}, {"id": "1", "name": "One"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Subscriptions[0].Managers, 5)
+
+	resp = new(SubscriptionResponse)
+	err = Unmarshal(data, resp, WithDedupeRelations(true))
+	assert.Nil(t, err)
+	managers := resp.Subscriptions[0].Managers
+	if assert.Len(t, managers, 3) {
+		assert.Equal(t, "Acme", managers[0].Name)
+		assert.Same(t, resp.Subscriptions[0].Account, managers[1])
+		assert.Equal(t, "One", managers[2].Name)
+	}
+}
+
+func TestWithStats(t *testing.T) {
+	var stats Stats
+	shelf := new(Shelf)
+	err := Unmarshal([]byte(`{
+		"owner_id": "u_1",
+		"reader_ids": ["u_1", "u_2", "u_9"],
+		"accounts": [{"id": "u_1"}, {"id": "u_2"}]
+	}`), shelf, WithStats(&stats))
+	assert.Nil(t, err)
+	assert.Equal(t, Stats{Resolved: 3, Misses: 1, CacheHits: 1}, stats)
+	assert.Same(t, shelf.Owner, shelf.Readers[0])
+
+	// objects left out by a where modifier are not resolved
+	stats = Stats{}
+	till := new(Till)
+	err = Unmarshal([]byte(`{
+		"id": 1,
+		"line_items": [{"id": 10, "cart_id": 1, "quantity": 1}, {"id": 11, "cart_id": 1, "quantity": 4}]
+	}`), till, WithStats(&stats))
+	assert.Nil(t, err)
+	assert.Len(t, till.Singles, 1)
+	assert.Equal(t, Stats{Resolved: 1}, stats)
+}
+
+func TestWithArrayIDPolicy(t *testing.T) {
+	data := []byte(`{"id": 1, "customer_id": [7], "customers": [{"id": 7, "name": "Ann"}, {"id": 8, "name": "Bob"}]}`)
+	err := Unmarshal(data, new(Order))
+	assert.EqualError(t, err, "Order.Customer: expecting a single id, got an array")
+
+	order := new(Order)
+	assert.Nil(t, Unmarshal(data, order, WithArrayIDPolicy(SingleArrayIDs)))
+	assert.Equal(t, "Ann", order.Customer.Name)
+
+	data = []byte(`{"id": 1, "customer_id": [8, 7], "customers": [{"id": 7, "name": "Ann"}, {"id": 8, "name": "Bob"}]}`)
+	err = Unmarshal(data, new(Order), WithArrayIDPolicy(SingleArrayIDs))
+	assert.EqualError(t, err, "Order.Customer: expecting a single id, got an array of 2")
+	order = new(Order)
+	assert.Nil(t, Unmarshal(data, order, WithArrayIDPolicy(FirstArrayID)))
+	assert.Equal(t, "Bob", order.Customer.Name)
+
+	order = new(Order)
+	assert.Nil(t, Unmarshal([]byte(`{"id": 1, "customer_id": []}`), order, WithArrayIDPolicy(FirstArrayID)))
+	assert.Nil(t, order.Customer)
+}
+
+func TestWithFieldNameMapper(t *testing.T) {
+	data := []byte(`{
+		"user_id": "u_1",
+		"created_at": "2019-03-01T10:00:00Z",
+		"http_score": 7,
+		"nick": "al",
+		"manager_id": "u_2",
+		"accounts": [{"id": "u_2", "name": "Acme"}]
+	}`)
+	member := new(Member)
+	err := Unmarshal(data, member, WithFieldNameMapper(SnakeCase), WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "u_1", member.UserID)
+	assert.Equal(t, time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC), member.CreatedAt)
+	assert.Equal(t, 7, member.HTTPScore)
+	assert.Equal(t, "al", member.Nickname)
+	assert.Equal(t, "Acme", member.Manager.Name)
+
+	member = new(Member)
+	assert.Nil(t, Unmarshal(data, member))
+	assert.Empty(t, member.UserID)
+
+	assert.Equal(t, "order_line_id", SnakeCase("OrderLineID"))
+	assert.Equal(t, "http_server2_url", SnakeCase("HTTPServer2URL"))
+}
+
+func TestWithTolerantFields(t *testing.T) {
+	data := []byte(`{"id": "one", "customer_id": 7, "customers": [{"id": 7, "name": 3, "order_ids": []}]}`)
+	err := Unmarshal(data, new(Order))
+	var typeErr *json.UnmarshalTypeError
+	if assert.True(t, errors.As(err, &typeErr)) {
+		assert.Equal(t, "id", typeErr.Field)
+	}
+
+	order := new(Order)
+	err = Unmarshal(data, order, WithTolerantFields(true))
+	assert.Zero(t, order.ID)
+	if assert.NotNil(t, order.Customer) {
+		assert.Equal(t, float64(7), order.Customer.ID)
+		assert.Empty(t, order.Customer.Name)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); assert.True(t, ok) {
+		errs := joined.Unwrap()
+		assert.Len(t, errs, 2)
+		assert.Contains(t, errs[0].Error(), "Order: json: cannot unmarshal string into Go struct field Order.id of type float64")
+		assert.Contains(t, errs[1].Error(), "Order.Customer: json: cannot unmarshal number into Go struct field Customer.name of type string")
+	}
+}
+
+func TestWithSideloadRoot(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"customer_id": 7,
+		"customers": [{"id": 7, "name": "Top"}],
+		"store": {"customers": [{"id": 7, "name": "Ann", "order_ids": [1]}], "orders": [{"id": 1}]}
+	}`)
+	order := new(Order)
+	err := Unmarshal(data, order, WithSideloadRoot("store"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+	assert.Len(t, order.Customer.Orders, 1)
+
+	order = new(Order)
+	err = Unmarshal([]byte(`{"id": 1, "customer_id": 7, "data": {"store": {"customers": [{"id": 7, "name": "Ann"}]}}}`),
+		order, WithSideloadRoot("data.store"), WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+
+	order = new(Order)
+	err = Unmarshal([]byte(`{"id": 1, "customer_id": 7}`), order, WithSideloadRoot("store"), WithStrictRelations(true))
+	assert.EqualError(t, err, "no sideloaded customers found with id 7 for Order.Customer")
+
+	// a root without relations still allows the sideload root, and its leaf children still do not
+	tag := new(Tag)
+	err = Unmarshal([]byte(`{"name": "go", "store": {"tags": []}}`), tag, WithSideloadRoot("store"), WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "go", tag.Name)
+	err = Unmarshal([]byte(`{"id": 1, "address": {"street": "Main", "store": {}}, "store": {}}`), new(Shipment),
+		WithSideloadRoot("store"), WithDisallowUnknownFields(true))
+	assert.EqualError(t, err, `unknown field "store" in Shipment.Address`)
+}
+
+func TestWithMatcher(t *testing.T) {
+	data := []byte(`{
+		"env": "prod",
+		"configs": [
+			{"id": 1, "environment": "dev", "name": "a"},
+			{"id": 2, "environment": "prod", "name": "b"},
+			{"id": 3, "environment": "prod", "name": "c"}
+		]
+	}`)
+	sameEnv := func(parent, candidate map[string]interface{}) bool {
+		return parent["env"] == candidate["environment"]
+	}
+	deployment := new(Deployment)
+	err := Unmarshal(data, deployment, WithMatcher("configs", sameEnv))
+	assert.Nil(t, err)
+	assert.Equal(t, "b", deployment.Config.Name)
+	if assert.Len(t, deployment.Services, 2) {
+		assert.Same(t, deployment.Config, deployment.Services[0])
+		assert.Equal(t, "c", deployment.Services[1].Name)
+	}
+
+	deployment = new(Deployment)
+	err = Unmarshal(data, deployment, WithMatcher("configs", func(parent, candidate map[string]interface{}) bool { return false }))
+	assert.Nil(t, err)
+	assert.Nil(t, deployment.Config)
+	assert.NotNil(t, deployment.Services)
+	assert.Empty(t, deployment.Services)
+}
+
+func TestWithRootKey(t *testing.T) {
+	data := []byte(`{
+		"data": {"id": 1, "customer_id": 7, "customers": [{"id": 7, "name": "Ann"}]},
+		"meta": {"page": 1}
+	}`)
+	order := new(Order)
+	err := Unmarshal(data, order, WithRootKey("data"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), order.ID)
+	assert.Equal(t, "Ann", order.Customer.Name)
+
+	var orders []*Order
+	err = Unmarshal([]byte(`{"data": [{"id": 1}, {"id": 2}]}`), &orders, WithRootKey("data"))
+	assert.Nil(t, err)
+	assert.Len(t, orders, 2)
+
+	err = Unmarshal([]byte(`{"id": 1}`), new(Order), WithRootKey("data"))
+	assert.EqualError(t, err, "root key data not found in the payload")
+	err = Unmarshal([]byte(`[{"id": 1}]`), &orders, WithRootKey("data"))
+	assert.EqualError(t, err, "malformed JSON provided: expecting an object holding data, got []interface {}")
+
+	refs, err := Validate([]byte(`{"data": {"id": 1, "customer_id": 8, "customers": []}}`), new(Order), WithRootKey("data"))
+	assert.Nil(t, err)
+	assert.Len(t, refs, 1)
+}
+
+func TestWithStores(t *testing.T) {
+	stores := map[string]map[string]interface{}{
+		"customers": {
+			"7": map[string]interface{}{"id": 7, "name": "Ann", "order_ids": []interface{}{2}},
+			"8": map[string]interface{}{"name": "Ben"},
+		},
+	}
+	order := new(Order)
+	err := Unmarshal([]byte(`{"id": 1, "customer_id": 7, "orders": [{"id": 2, "customer_id": 8}]}`), order, WithStores(stores))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(7), order.Customer.ID)
+	assert.Equal(t, "Ann", order.Customer.Name)
+	if assert.Len(t, order.Customer.Orders, 1) {
+		assert.Equal(t, "Ben", order.Customer.Orders[0].Customer.Name)
+	}
+
+	order = new(Order)
+	err = Unmarshal([]byte(`{"id": 1, "customer_id": 7, "customers": [{"id": 7, "name": "Bob"}]}`), order, WithStores(stores))
+	assert.Nil(t, err)
+	assert.Equal(t, "Bob", order.Customer.Name)
+
+	order = new(Order)
+	err = Unmarshal([]byte(`{"id": 1, "customer_id": 9}`), order, WithStores(stores), WithStrictRelations(true))
+	assert.EqualError(t, err, "no sideloaded customers found with id 9 for Order.Customer")
+
+	broken := map[string]map[string]interface{}{
+		"customers": {"7": map[string]interface{}{"id": 7, "name": "Ann"}, "8": map[string]interface{}{"id": 8, "updates": make(chan int)}},
+	}
+	expected := "malformed store object customers 8 provided: json: unsupported type: chan int"
+	order = new(Order)
+	err = Unmarshal([]byte(`{"id": 1, "customer_id": 7}`), order, WithStores(broken))
+	assert.EqualError(t, err, expected)
+	err = UnmarshalMany([]byte(`{"orders": [{"id": 1}]}`), "orders", &[]*Order{}, WithStores(broken))
+	assert.EqualError(t, err, expected)
+	_, err = Validate([]byte(`{"id": 1}`), new(Order), WithStores(broken))
+	assert.EqualError(t, err, expected)
+}
+
+func TestWithPreprocessor(t *testing.T) {
+	data := []byte(`{
+		"id": 1,
+		"customer_id": 7,
+		"customers": [{"id": 7, "full_name": "Ann"}]
+	}`)
+	var seen []map[string]interface{}
+	rename := func(obj map[string]interface{}) map[string]interface{} {
+		seen = append(seen, obj)
+		renamed := make(map[string]interface{}, len(obj))
+		for key, value := range obj {
+			renamed[key] = value
+		}
+		renamed["name"] = obj["full_name"]
+		return renamed
+	}
+	order := new(Order)
+	source, err := UnmarshalWithSource(data, order, WithPreprocessor("customers", rename))
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+	assert.Len(t, seen, 1)
+	customers := source["customers"].([]interface{})
+	assert.NotContains(t, customers[0], "name")
+
+	order = new(Order)
+	err = Unmarshal(data, order, WithPreprocessor("customers", func(map[string]interface{}) map[string]interface{} { return nil }))
+	assert.Nil(t, err)
+	assert.Equal(t, "", order.Customer.Name)
+	assert.Equal(t, float64(7), order.Customer.ID)
+}
+
+func TestWithInlineRelations(t *testing.T) {
+	inlined := []byte(`{"id": 1, "customer": {"id": 7, "name": "Ann"}}`)
+	sideloaded := []byte(`{"id": 1, "customer_id": 7, "customers": [{"id": 7, "name": "Bob"}]}`)
+
+	order := new(Order)
+	assert.Nil(t, Unmarshal(inlined, order))
+	assert.Nil(t, order.Customer)
+
+	order = new(Order)
+	assert.Nil(t, Unmarshal(inlined, order, WithInlineRelations(true), WithDisallowUnknownFields(true)))
+	assert.Equal(t, "Ann", order.Customer.Name)
+
+	order = new(Order)
+	assert.Nil(t, Unmarshal(sideloaded, order, WithInlineRelations(true)))
+	assert.Equal(t, "Bob", order.Customer.Name)
+
+	// an id without a sideloaded object falls back to the inlined one before failing strict decoding
+	order = new(Order)
+	err := Unmarshal([]byte(`{"id": 1, "customer_id": 7, "customer": {"id": 7, "name": "Ann"}}`), order,
+		WithInlineRelations(true), WithStrictRelations(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "Ann", order.Customer.Name)
+
+	err = Unmarshal([]byte(`{"id": 1, "customer_id": 7}`), new(Order), WithInlineRelations(true), WithStrictRelations(true))
+	assert.EqualError(t, err, "no sideloaded customers found with id 7 for Order.Customer")
+
+	err = Unmarshal([]byte(`{"id": 1, "customer": {"id": "seven"}}`), new(Order), WithInlineRelations(true))
+	assert.EqualError(t, err, "Order.Customer: json: cannot unmarshal string into Go struct field Customer.id of type float64")
+}
+
+func TestWithOnlyRelations(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_456"]}],
+		"accounts": [{"id": "u_123", "name": "Acme"}, {"id": "u_456", "name": "Globex"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	err := Unmarshal(data, resp, WithOnlyRelations("subscriptions", "account"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", resp.Subscriptions[0].Account.Name)
+	assert.Nil(t, resp.Subscriptions[0].Managers)
+
+	resp = new(SubscriptionResponse)
+	err = Unmarshal(data, resp, WithOnlyRelations("account"))
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Subscriptions)
+
+	// by the relation key of the tag as well, with skipped relations winning
+	resp = new(SubscriptionResponse)
+	err = Unmarshal(data, resp, WithOnlyRelations("subscriptions", "accounts"), WithSkipRelations("managers"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", resp.Subscriptions[0].Account.Name)
+	assert.Nil(t, resp.Subscriptions[0].Managers)
+
+	resp = new(SubscriptionResponse)
+	err = Unmarshal(data, resp, WithSkipRelations("account"), WithStrictRelations(true), WithDisallowUnknownFields(true))
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Subscriptions[0].Account)
+	assert.Equal(t, "Globex", resp.Subscriptions[0].Managers[0].Name)
+}
+
+func TestWithLooseScalarCoercion(t *testing.T) {
+	data := []byte(`{
+		"id": 1, "destination_id": 5,
+		"destinations": [{"id": 5, "zip": 90210, "floor": " 3 ", "suite": 12}]
+	}`)
+	consignment := new(Consignment)
+	err := Unmarshal(data, consignment, WithLooseScalarCoercion(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "90210", consignment.Destination.Zip)
+	assert.Equal(t, 3, consignment.Destination.Floor)
+	assert.Equal(t, "12", *consignment.Destination.Suite)
+
+	err = Unmarshal(data, new(Consignment))
+	assert.EqualError(t, err, "Consignment.Destination: json: cannot unmarshal number into Go struct field Destination.zip of type string")
+
+	// strings not holding a number stay an error
+	err = Unmarshal([]byte(`{"id": 1, "destination_id": 5, "destinations": [{"id": 5, "floor": "third"}]}`), new(Consignment),
+		WithLooseScalarCoercion(true))
+	assert.EqualError(t, err, "Consignment.Destination: json: cannot unmarshal string into Go struct field Destination.floor of type int")
+}
@@ -0,0 +1,499 @@
+package jsonsideload
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldPlan - how a jsonsideload tagged struct field is decoded, parsed once per struct type
+type fieldPlan struct {
+	// index is the field index sequence, longer for fields promoted from embedded structs
+	index      []int
+	name       string
+	annotation string
+	relation   string
+	// jsonKey is the key of the json tag of the field, its Go name without one and empty for "-"
+	jsonKey string
+	// idField is the key of the node holding the relation id(s) of hasone and hasmany
+	idField string
+	// idPath is idField split on dots, for ids nested in the node
+	idPath []string
+	// lookupKey is the key matched on the sideloaded objects, empty for the configured default
+	lookupKey string
+	// backReference is the key of the sideloaded object pointing back at lookupKey of the node, for a hasone
+	// matched the other way around like order_id=id
+	backReference string
+	// scopeFields are the id fields before the last one of a compound id, like tenant_id in tenant_id+account_id
+	scopeFields []string
+	// scopeLookupKeys are the keys of the sideloaded objects matched against the scopeFields
+	scopeLookupKeys []string
+	// candidates are the collections a hasone is searched in, picked by the value of the discriminator key of
+	// the node, like users|organizations with owner_type
+	candidates    []string
+	discriminator string
+	// filters are the values the keys of the sideloaded objects must hold to be related, by key
+	filters []relationFilter
+	// omitEmpty leaves the relation out of marshaled payloads when it holds nothing
+	omitEmpty bool
+	// condition is the key of the node whose truthy value the relation is resolved on, empty for always
+	condition string
+	// zipped are the keys of the parallel arrays of a zip, like prices and labels in prices+labels
+	zipped []string
+	// flattenField is the field of a flatten annotation, decoded from the nested object under relation
+	flattenField *primitiveField
+	// referenceField is the primitive field named after the id field but keyed differently, like AccountID
+	// for account_id, nil if there is none
+	referenceField *primitiveField
+	// err is a problem with the tag, reported when the field is reached
+	err error
+}
+
+// typePlans caches the []fieldPlan of every struct type decoded so far, safe for concurrent use
+var typePlans sync.Map
+
+// typePlan - the plans of the tagged fields of the struct type, in field order
+func typePlan(structType reflect.Type) []fieldPlan {
+	if plan, ok := typePlans.Load(structType); ok {
+		return plan.([]fieldPlan)
+	}
+	plan, _ := typePlans.LoadOrStore(structType, buildTypePlan(structType))
+	return plan.([]fieldPlan)
+}
+
+func buildTypePlan(modelType reflect.Type) []fieldPlan {
+	var plan []fieldPlan
+	for _, field := range appendTypePlan(nil, modelType, nil, map[reflect.Type]bool{modelType: true}) {
+		// keeping promoted fields only where Go resolves the name to them, so shadowed and ambiguous ones drop out
+		if promoted, ok := modelType.FieldByName(field.name); ok && reflect.DeepEqual(promoted.Index, field.index) {
+			plan = append(plan, field)
+		}
+	}
+	var rest string
+	for i := range plan {
+		if plan[i].annotation == annotationRest && rest != "" {
+			plan[i].err = fmt.Errorf("only one rest field allowed per struct, got %s and %s", rest, plan[i].name)
+		} else if plan[i].annotation == annotationRest {
+			rest = plan[i].name
+		}
+	}
+	primitives := primitivePlanOf(modelType)
+	for i := range plan {
+		if plan[i].annotation == annotationHasOneRelation || plan[i].annotation == annotationHasManyRelation {
+			plan[i].referenceField = referenceField(primitives, plan, plan[i].idField)
+		}
+	}
+	return plan
+}
+
+// leafTypes caches whether each struct type decoded so far is a leaf, safe for concurrent use
+var leafTypes sync.Map
+
+var loadedSetterType = reflect.TypeOf((*LoadedSetter)(nil)).Elem()
+
+// isLeafType - reports whether the objects of the struct type are decoded from their primitive fields alone: it has
+// no tagged fields, does not decode itself and is not told which relations were loaded
+func isLeafType(structType reflect.Type) bool {
+	if leaf, ok := leafTypes.Load(structType); ok {
+		return leaf.(bool)
+	}
+	modelType := reflect.PtrTo(structType)
+	leaf := structType.Kind() == reflect.Struct && len(typePlan(structType)) == 0 &&
+		!modelType.Implements(jsonUnmarshalerType) && !modelType.Implements(loadedSetterType)
+	leafTypes.Store(structType, leaf)
+	return leaf
+}
+
+// referenceField - the untagged primitive field holding the ids of idField under another key, like AccountID
+// or accountId for account_id. Nil when a field has the key itself, which the primitive pass fills.
+func referenceField(primitives *primitivePlan, plan []fieldPlan, idField string) *primitiveField {
+	if idField == "" || primitives.has(idField, nil) {
+		return nil
+	}
+	name := normalizeFieldName(idField)
+	for i, field := range primitives.fields {
+		if normalizeFieldName(field.name) != name || isRelationField(plan, field.index) {
+			continue
+		}
+		return &primitives.fields[i]
+	}
+	return nil
+}
+
+// normalizeFieldName - lower cases the name and drops its separators, to match account_id with AccountID
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", ".", "", "-", "").Replace(name))
+}
+
+// isRelationField - reports whether the field at index is one of the tagged fields of the plan
+func isRelationField(plan []fieldPlan, index []int) bool {
+	for _, field := range plan {
+		if reflect.DeepEqual(field.index, index) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendTypePlan - appends the plans of the tagged fields of the struct type, walking into embedded structs
+func appendTypePlan(plan []fieldPlan, t reflect.Type, index []int, visited map[reflect.Type]bool) []fieldPlan {
+	for i := 0; i < t.NumField(); i++ {
+		fieldType := t.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+		tag := fieldType.Tag.Get(annotationJSONSideload)
+		if tag == "" { // Ignoring the fields which doesn't have 'jsonsideload' tags, other than embedded structs
+			if embedded := structType(fieldType.Type); fieldType.Anonymous && embedded.Kind() == reflect.Struct && !visited[embedded] {
+				visited[embedded] = true
+				plan = appendTypePlan(plan, embedded, fieldIndex, visited)
+			}
+			continue
+		}
+		plan = append(plan, parseFieldPlan(fieldIndex, fieldType, tag))
+	}
+	return plan
+}
+
+// parseFieldPlan - parses the tag of the field, checking it fits the field type
+func parseFieldPlan(index []int, fieldType reflect.StructField, tag string) fieldPlan {
+	modifiers := strings.Split(tag, modifierSeparator)
+	args := strings.Split(modifiers[0], ",")
+	field := fieldPlan{index: index, name: fieldType.Name, annotation: args[0]}
+	switch field.jsonKey = strings.Split(fieldType.Tag.Get("json"), ",")[0]; field.jsonKey {
+	case "":
+		field.jsonKey = fieldType.Name
+	case "-":
+		field.jsonKey = ""
+	}
+	if len(args) > 2 && args[len(args)-1] == "omitempty" { // not an argument, whatever the position
+		field.omitEmpty = true
+		args = args[:len(args)-1]
+	}
+	for _, modifier := range modifiers[1:] {
+		if condition := strings.TrimPrefix(modifier, "if="); condition != modifier && condition != "" {
+			field.condition = condition
+			continue
+		}
+		if filter := strings.SplitN(strings.TrimPrefix(modifier, "where="), "=", 2); strings.HasPrefix(modifier, "where=") &&
+			len(filter) == 2 && filter[0] != "" {
+			field.filters = append(field.filters, relationFilter{key: filter[0], value: filter[1]})
+			continue
+		}
+		field.err = fmt.Errorf("unknown jsonsideload modifier %q on field %s", modifier, fieldType.Name)
+		return field
+	}
+	if len(args) > 1 {
+		field.relation = args[1]
+	}
+	if strings.Contains(field.relation, candidateSeparator) {
+		field.candidates = strings.Split(field.relation, candidateSeparator)
+		if len(args) > 3 { // the discriminator comes before the lookup key
+			field.discriminator = args[3]
+			args = append(args[:3:3], args[4:]...)
+		}
+	}
+	if len(args) > 2 {
+		idFields := strings.Split(args[2], compoundSeparator)
+		field.idField = idFields[len(idFields)-1]
+		field.idPath = strings.Split(field.idField, ".")
+		field.scopeFields = idFields[:len(idFields)-1]
+		field.scopeLookupKeys = field.scopeFields
+	}
+	if len(args) > 3 && field.idField == "" && strings.Contains(args[3], "=") { // back reference like order_id=id
+		backReference := strings.SplitN(args[3], "=", 2)
+		field.backReference, field.lookupKey = backReference[0], backReference[1]
+		field.idPath = nil
+	} else if len(args) > 3 {
+		lookupKeys := strings.Split(args[3], compoundSeparator)
+		field.lookupKey = lookupKeys[len(lookupKeys)-1]
+		if len(lookupKeys) > 1 || len(field.scopeFields) > 0 {
+			field.scopeLookupKeys = lookupKeys[:len(lookupKeys)-1]
+		}
+	}
+
+	if !isAnnotation(field.annotation) { // catching typos like hasOne rather than skipping the field
+		field.err = fmt.Errorf("unknown jsonsideload annotation %q on field %s", field.annotation, fieldType.Name)
+		return field
+	}
+	if fieldType.PkgPath != "" { // reflection cannot set unexported fields
+		field.err = fmt.Errorf("jsonsideload tag on unexported field %s, which cannot be set", fieldType.Name)
+		return field
+	}
+	if field.annotation == annotationFlatten {
+		flattenField := newPrimitiveField(fieldType, index)
+		field.flattenField = &flattenField
+		if len(args) < 2 || field.relation == "" {
+			field.err = fmt.Errorf("no nested object found in annotation for %s", fieldType.Name)
+		}
+		return field
+	}
+	if field.annotation == annotationRest {
+		if fieldType.Type.Kind() != reflect.Map || fieldType.Type.Key().Kind() != reflect.String {
+			field.err = fmt.Errorf("expecting a map keyed by string for %s in struct", fieldType.Name)
+		}
+		return field
+	}
+	if field.annotation == annotationCount {
+		switch {
+		case len(args) < 2 || field.relation == "":
+			field.err = fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
+		case fieldType.Type.Kind() < reflect.Int || fieldType.Type.Kind() > reflect.Uintptr:
+			field.err = fmt.Errorf("expecting an integer type for %s in struct", fieldType.Name)
+		}
+		return field
+	}
+	if field.annotation == annotationZip {
+		field.zipped = strings.Split(field.relation, compoundSeparator)
+		switch {
+		case len(args) < 2 || field.relation == "":
+			field.err = fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
+		case (fieldType.Type.Kind() != reflect.Slice && fieldType.Type.Kind() != reflect.Array) ||
+			!isRelationType(fieldType.Type.Elem()):
+			field.err = fmt.Errorf("%w for %s in struct", ErrExpectPointerSlice, fieldType.Name)
+		}
+		return field
+	}
+	if field.annotation == annotationRaw {
+		switch {
+		case len(args) < 2 || field.relation == "":
+			field.err = fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
+		case fieldType.Type != rawMessageType && (fieldType.Type.Kind() != reflect.Slice || fieldType.Type.Elem() != rawMessageType):
+			field.err = fmt.Errorf("expecting json.RawMessage or []json.RawMessage for %s in struct", fieldType.Name)
+		}
+		return field
+	}
+	isSideloaded := field.annotation == annotationHasOneRelation || field.annotation == annotationHasManyRelation ||
+		field.annotation == annotationHasManyReverse
+	isSingle := field.annotation == annotationInclude || field.annotation == annotationIncludeFirst ||
+		field.annotation == annotationIncludeLast || field.annotation == annotationHasOneRelation
+	switch {
+	case len(args) < 2:
+		field.err = fmt.Errorf("no relationship found in annotation for %s", fieldType.Name)
+	case field.backReference != "" && (field.annotation != annotationHasOneRelation || field.lookupKey == ""):
+		field.err = fmt.Errorf("%s relation %s cannot match the back reference %s", field.annotation, fieldType.Name, args[3])
+	case isSideloaded && field.idField == "" && field.backReference == "":
+		field.err = fmt.Errorf("%s relation %s requires an id field argument", field.annotation, fieldType.Name)
+	case len(field.candidates) > 0 && (field.annotation != annotationHasOneRelation || field.backReference != ""):
+		field.err = fmt.Errorf("%s relation %s cannot search several collections", field.annotation, fieldType.Name)
+	case len(field.candidates) > 0 && field.discriminator == "":
+		field.err = fmt.Errorf("%s relation %s requires a discriminator field to pick one of %s", field.annotation,
+			fieldType.Name, field.relation)
+	case len(field.scopeLookupKeys) != len(field.scopeFields):
+		field.err = fmt.Errorf("%s relation %s has %d id fields but %d lookup keys", field.annotation, fieldType.Name,
+			len(field.scopeFields)+1, len(field.scopeLookupKeys)+1)
+	case field.annotation == annotationHasManyReverse && len(field.scopeFields) > 0:
+		field.err = fmt.Errorf("%s relation %s does not support compound ids", field.annotation, fieldType.Name)
+	case isSingle && !isRelationType(fieldType.Type): // Only pointer and struct types are allowed in struct
+		field.err = fmt.Errorf("%w for %s in struct", ErrExpectPointer, fieldType.Name)
+	case field.annotation == annotationHasManyRelation && fieldType.Type.Kind() == reflect.Map:
+		if fieldType.Type.Key().Kind() != reflect.String || !isRelationType(fieldType.Type.Elem()) {
+			field.err = fmt.Errorf("expecting map of pointers keyed by string for %s in struct", fieldType.Name)
+		}
+	case !isSingle && ((fieldType.Type.Kind() != reflect.Slice && fieldType.Type.Kind() != reflect.Array) ||
+		!isRelationType(fieldType.Type.Elem())):
+		field.err = fmt.Errorf("%w for %s in struct", ErrExpectPointerSlice, fieldType.Name)
+	}
+	return field
+}
+
+// relationFilter - a where modifier of a tag, like where=status=active
+type relationFilter struct {
+	key   string
+	value string
+}
+
+// accepts - reports whether the sideloaded object holds the value of every filter of the field, compared in the
+// canonical form of ids so that where=rank=1 matches 1 and "1"
+func (f fieldPlan) accepts(relationMap map[string]interface{}) bool {
+	for _, filter := range f.filters {
+		if value, ok := canonicalID(relationMap[filter.key]); !ok || value != filter.value {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptedOf - the sideloaded objects the filters of the field accept, in order
+func (f fieldPlan) acceptedOf(relationMaps []map[string]interface{}) []map[string]interface{} {
+	if len(f.filters) == 0 {
+		return relationMaps
+	}
+	accepted := make([]map[string]interface{}, 0, len(relationMaps))
+	for _, relationMap := range relationMaps {
+		if f.accepts(relationMap) {
+			accepted = append(accepted, relationMap)
+		}
+	}
+	return accepted
+}
+
+// isRelation - reports whether the field holds related objects, rather than values read from the node like
+// the flatten, count and rest fields
+func (f fieldPlan) isRelation() bool {
+	return f.annotation != annotationFlatten && f.annotation != annotationCount && f.annotation != annotationRest
+}
+
+// hasRestField - reports whether the struct type has a rest field collecting the keys nothing else reads
+func hasRestField(structType reflect.Type) bool {
+	for _, field := range typePlan(structType) {
+		if field.annotation == annotationRest {
+			return true
+		}
+	}
+	return false
+}
+
+// isRelationType - reports whether relations can be decoded into values of typ, a struct or a pointer to one,
+// or an interface for polymorphic relations
+func isRelationType(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Struct || typ.Kind() == reflect.Interface
+}
+
+// idValue - the value of the id field in the node, following dotted paths into nested objects
+func (f fieldPlan) idValue(node map[string]interface{}) interface{} {
+	if v, ok := node[f.idField]; ok || len(f.idPath) < 2 {
+		return v
+	}
+	return valueAtPath(node, f.idPath)
+}
+
+// lookupKeyOr - the lookup key named by the tag, else defaultKey
+func (f fieldPlan) lookupKeyOr(defaultKey string) string {
+	if f.lookupKey != "" {
+		return f.lookupKey
+	}
+	return defaultKey
+}
+
+// parentKey - the key of the node the sideloaded objects point back at, defaultKey for a hasmany_reverse without a
+// lookup key and empty for relations looked up by id
+func (f fieldPlan) parentKey(defaultKey string) string {
+	switch {
+	case f.backReference != "":
+		return f.lookupKey
+	case f.annotation == annotationHasManyReverse:
+		return f.lookupKeyOr(defaultKey)
+	}
+	return ""
+}
+
+// indexLookupKey - the lookup key of the relation's index, joining the scope keys of compound ids
+func (f fieldPlan) indexLookupKey(defaultKey string) string {
+	lookupKey := f.lookupKeyOr(defaultKey)
+	if len(f.scopeFields) == 0 {
+		return lookupKey
+	}
+	return strings.Join(f.scopeLookupKeys, compoundSeparator) + compoundSeparator + lookupKey
+}
+
+// relationLookup - the index lookup key and the id to find for the id held by the node,
+// compound ids joining the values of the scope fields
+func (f fieldPlan) relationLookup(node map[string]interface{}, id interface{}, defaultKey string) (string, interface{}) {
+	if len(f.scopeFields) == 0 {
+		return f.lookupKeyOr(defaultKey), id
+	}
+	values := make([]interface{}, 0, len(f.scopeFields)+1)
+	for _, scopeField := range f.scopeFields {
+		values = append(values, node[scopeField])
+	}
+	return f.indexLookupKey(defaultKey), compoundID(append(values, id))
+}
+
+// RegisterType - checks the jsonsideload tags of the struct model points to and of every struct it relates to,
+// returning TagErrors listing each problem. The checked plans are kept, saving their parsing on first decode.
+func RegisterType(model interface{}) error {
+	modelType := reflect.TypeOf(model)
+	if modelType == nil || structType(modelType).Kind() != reflect.Struct {
+		return fmt.Errorf("expecting a struct or a pointer to one, got %T", model)
+	}
+	var problems TagErrors
+	checkTypePlan(structType(modelType), make(map[reflect.Type]bool), &problems)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// RelationInfo - a relationship declared by a jsonsideload tag, reported by Relations
+type RelationInfo struct {
+	// Field is the Go name of the struct field
+	Field string
+	// Annotation is the kind of relationship, like include, includes, hasone or hasmany
+	Annotation string
+	// Relation is the key the relationship is read from, like the sideloaded array of a hasone
+	Relation string
+	// IDField is the key of the node holding the id(s) of a hasone or hasmany, compound ones joined by +
+	IDField string
+	// LookupKey is the key matched on the sideloaded objects, empty for the configured default
+	LookupKey string
+	// Type is the type of the related models, the element type for slices, arrays and maps
+	Type reflect.Type
+}
+
+// Relations - lists the relationships the jsonsideload tags of the struct model points to declare, in field
+// order, without decoding anything. A wrong tag fails with the TagError RegisterType reports for it.
+func Relations(model interface{}) ([]RelationInfo, error) {
+	modelType := reflect.TypeOf(model)
+	if modelType == nil || structType(modelType).Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expecting a struct or a pointer to one, got %T", model)
+	}
+	modelType = structType(modelType)
+	var relations []RelationInfo
+	for _, field := range typePlan(modelType) {
+		fieldType := modelType.FieldByIndex(field.index)
+		if field.err != nil {
+			return nil, &TagError{Type: typeName(modelType), Field: field.name, Tag: fieldType.Tag.Get(annotationJSONSideload),
+				Reason: field.err.Error(), Err: field.err}
+		}
+		relationType := fieldType.Type
+		if field.isRelation() && (relationType.Kind() == reflect.Slice || relationType.Kind() == reflect.Array ||
+			relationType.Kind() == reflect.Map) {
+			relationType = relationType.Elem()
+		}
+		idField, lookupKey := field.idField, field.lookupKey
+		if len(field.scopeFields) > 0 { // compound ids, written back the way the tag spells them
+			idField = strings.Join(field.scopeFields, compoundSeparator) + compoundSeparator + idField
+			if lookupKey != "" {
+				lookupKey = strings.Join(field.scopeLookupKeys, compoundSeparator) + compoundSeparator + lookupKey
+			}
+		}
+		relations = append(relations, RelationInfo{Field: field.name, Annotation: field.annotation, Relation: field.relation,
+			IDField: idField, LookupKey: lookupKey, Type: structType(relationType)})
+	}
+	return relations, nil
+}
+
+// checkTypePlan - adds the problems of the tags of the struct type and the types it relates to
+func checkTypePlan(modelType reflect.Type, seen map[reflect.Type]bool, problems *TagErrors) {
+	if modelType.Kind() != reflect.Struct || seen[modelType] {
+		return
+	}
+	seen[modelType] = true
+	for _, field := range typePlan(modelType) {
+		fieldType := modelType.FieldByIndex(field.index)
+		problem := &TagError{Type: typeName(modelType), Field: field.name, Tag: fieldType.Tag.Get(annotationJSONSideload)}
+		switch {
+		case field.err != nil:
+			problem.Reason, problem.Err = field.err.Error(), field.err
+		default:
+			relationType := fieldType.Type
+			if relationType.Kind() == reflect.Slice || relationType.Kind() == reflect.Array || relationType.Kind() == reflect.Map {
+				relationType = relationType.Elem()
+			}
+			checkTypePlan(structType(relationType), seen, problems)
+			continue
+		}
+		*problems = append(*problems, problem)
+	}
+}
+
+// isAnnotation - reports whether the annotation is one of the known relation annotations
+func isAnnotation(annotation string) bool {
+	switch annotation {
+	case annotationInclude, annotationIncludes, annotationHasOneRelation, annotationHasManyRelation, annotationHasManyReverse,
+		annotationIncludeFirst, annotationIncludeLast, annotationFlatten, annotationRaw, annotationCount, annotationRest,
+		annotationZip:
+		return true
+	}
+	return false
+}
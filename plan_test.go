@@ -0,0 +1,67 @@
+package jsonsideload
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterType(t *testing.T) {
+	assert.Nil(t, RegisterType(new(SubscriptionResponse)))
+	assert.Nil(t, RegisterType(Order{}))
+	assert.EqualError(t, RegisterType(3), "expecting a struct or a pointer to one, got int")
+
+	err := RegisterType(new(Misconfigured))
+	problems, ok := err.(TagErrors)
+	if assert.True(t, ok) && assert.Len(t, problems, 4) {
+		assert.Equal(t, &TagError{
+			Type:   "Misconfigured",
+			Field:  "Account",
+			Tag:    "hasone,accounts",
+			Reason: "hasone relation Account requires an id field argument",
+			Err:    errors.New("hasone relation Account requires an id field argument"),
+		}, problems[0])
+		assert.Equal(t, "Misconfigured.Address `jsonsideload:\"includ,address\"`: unknown jsonsideload annotation \"includ\" on field Address", problems[1].Error())
+		assert.Equal(t, "Partner", problems[2].Type)
+		assert.Equal(t, "expecting pointer type for Count in struct", problems[2].Reason)
+		assert.True(t, errors.Is(problems[2], ErrExpectPointer))
+		assert.True(t, errors.Is(err, ErrExpectPointer))
+		assert.False(t, errors.Is(err, ErrExpectPointerSlice))
+		assert.Equal(t, "hasmany relation Accounts has 1 id fields but 2 lookup keys", problems[3].Reason)
+	}
+}
+
+func TestIsLeafType(t *testing.T) {
+	assert.True(t, isLeafType(reflect.TypeOf(Tag{})))
+	assert.True(t, isLeafType(reflect.TypeOf(Corner{})))
+	assert.False(t, isLeafType(reflect.TypeOf(Order{})))
+	assert.False(t, isLeafType(reflect.TypeOf(Money{})))
+	assert.False(t, isLeafType(reflect.TypeOf(Shelf{})))
+}
+
+func TestRelations(t *testing.T) {
+	relations, err := Relations(new(Warehouse))
+	assert.Nil(t, err)
+	assert.Equal(t, []RelationInfo{
+		{Field: "Address", Annotation: "include", Relation: "address", Type: reflect.TypeOf(Address{})},
+		{Field: "Bins", Annotation: "includes", Relation: "bins", Type: reflect.TypeOf(Item{})},
+		{Field: "Manager", Annotation: "hasone", Relation: "owners", IDField: "tenant_id+manager_uuid", LookupKey: "tenant+uuid",
+			Type: reflect.TypeOf(Owner{})},
+		{Field: "Carriers", Annotation: "hasmany", Relation: "carriers", IDField: "carrier_ids", Type: reflect.TypeOf(Carrier{})},
+		{Field: "Stock", Annotation: "count", Relation: "bins", Type: reflect.TypeOf(0)},
+	}, relations)
+
+	relations, err = Relations(Address{})
+	assert.Nil(t, err)
+	assert.Empty(t, relations)
+
+	_, err = Relations(3)
+	assert.EqualError(t, err, "expecting a struct or a pointer to one, got int")
+
+	_, err = Relations(new(Misconfigured))
+	var tagErr *TagError
+	assert.True(t, errors.As(err, &tagErr))
+	assert.Equal(t, "Misconfigured", tagErr.Type)
+}
@@ -0,0 +1,442 @@
+package jsonsideload
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// primitiveField - a struct field filled straight from the decoded node, found the way encoding/json finds it
+type primitiveField struct {
+	index []int
+	name  string
+	// untagged is set for fields named by their Go name, which the configured field name mapper renames
+	untagged bool
+	// quoted is the ",string" option of the json tag
+	quoted bool
+	// custom is set for types decoding themselves, which always go through encoding/json
+	custom bool
+	// isTime is set for time.Time and *time.Time fields, parsed with the configured time layout
+	isTime bool
+	// textNumber is set for types decoding themselves from text alone, like *big.Float, which take JSON numbers too
+	textNumber bool
+}
+
+// primitivePlan - the primitive fields of a struct type, by json key
+type primitivePlan struct {
+	structName string
+	fields     []primitiveField
+	byName     map[string]int
+}
+
+// primitivePlans caches the *primitivePlan of every struct type decoded so far, safe for concurrent use
+var primitivePlans sync.Map
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	rawMessageType      = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// primitivePlanOf - the primitive fields of the struct type
+func primitivePlanOf(structType reflect.Type) *primitivePlan {
+	if plan, ok := primitivePlans.Load(structType); ok {
+		return plan.(*primitivePlan)
+	}
+	plan, _ := primitivePlans.LoadOrStore(structType, buildPrimitivePlan(structType))
+	return plan.(*primitivePlan)
+}
+
+func buildPrimitivePlan(modelType reflect.Type) *primitivePlan {
+	type candidate struct {
+		primitiveField
+		depth  int
+		tagged bool
+	}
+	var candidates []candidate
+	visited := map[reflect.Type]bool{modelType: true}
+	var walk func(t reflect.Type, index []int, depth int)
+	walk = func(t reflect.Type, index []int, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" || hasRelationTag(sf) { // relations are filled by their annotation alone, whatever their json key
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			fieldIndex := append(append([]int(nil), index...), i)
+			if sf.Anonymous {
+				ft := structType(sf.Type)
+				if sf.PkgPath != "" && ft.Kind() != reflect.Struct {
+					continue
+				}
+				if name == "" && ft.Kind() == reflect.Struct { // promoting the fields of embedded structs
+					if !visited[ft] {
+						visited[ft] = true
+						walk(ft, fieldIndex, depth+1)
+					}
+					continue
+				}
+			} else if sf.PkgPath != "" {
+				continue
+			}
+			candidates = append(candidates, candidate{primitiveField: newPrimitiveField(sf, fieldIndex), depth: depth, tagged: name != ""})
+		}
+	}
+	walk(modelType, nil, 0)
+
+	// as in encoding/json the shallowest field of a name wins, a tagged one breaking ties, and ambiguous names are dropped
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].name != candidates[j].name {
+			return candidates[i].name < candidates[j].name
+		}
+		if candidates[i].depth != candidates[j].depth {
+			return candidates[i].depth < candidates[j].depth
+		}
+		return candidates[i].tagged && !candidates[j].tagged
+	})
+	var fields []primitiveField
+	for i := 0; i < len(candidates); {
+		j := i + 1
+		for j < len(candidates) && candidates[j].name == candidates[i].name {
+			j++
+		}
+		first := candidates[i]
+		if j-i == 1 || candidates[i+1].depth > first.depth || (first.tagged && !candidates[i+1].tagged) {
+			fields = append(fields, first.primitiveField)
+		}
+		i = j
+	}
+	sort.Slice(fields, func(i, j int) bool { return indexLess(fields[i].index, fields[j].index) })
+
+	plan := &primitivePlan{structName: typeName(modelType), fields: fields, byName: make(map[string]int, len(fields))}
+	for i, field := range fields {
+		plan.byName[field.name] = i
+	}
+	return plan
+}
+
+// newPrimitiveField - the primitive field for the struct field at index, keyed by its json name
+func newPrimitiveField(sf reflect.StructField, index []int) primitiveField {
+	tag := sf.Tag.Get("json")
+	field := primitiveField{index: index, name: strings.Split(tag, ",")[0]}
+	if field.name == "" {
+		field.name, field.untagged = sf.Name, true
+	}
+	field.quoted = strings.Contains(tag, ",string") && isQuotable(sf.Type)
+	field.custom = sf.Type.Implements(jsonUnmarshalerType) || reflect.PtrTo(sf.Type).Implements(jsonUnmarshalerType) ||
+		sf.Type.Implements(textUnmarshalerType) || reflect.PtrTo(sf.Type).Implements(textUnmarshalerType)
+	field.isTime = structType(sf.Type) == timeType
+	field.textNumber = !sf.Type.Implements(jsonUnmarshalerType) && !reflect.PtrTo(sf.Type).Implements(jsonUnmarshalerType) &&
+		(sf.Type.Implements(textUnmarshalerType) || reflect.PtrTo(sf.Type).Implements(textUnmarshalerType))
+	return field
+}
+
+// isQuotable - reports whether the ",string" option applies to the type, as it does to strings, numbers and bools
+func isQuotable(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// indexLess - orders field index sequences as the fields appear in the struct
+func indexLess(a, b []int) bool {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// has - reports whether a json key of the node is decoded into one of the fields
+func (p *primitivePlan) has(key string, mapper func(string) string) bool {
+	if _, ok := p.byName[key]; ok {
+		return true
+	}
+	for _, field := range p.fields {
+		if strings.EqualFold(field.name, key) || (field.untagged && mapper != nil && mapper(field.name) == key) {
+			return true
+		}
+	}
+	return false
+}
+
+// values - the node value of every field present in it, matching keys exactly first and then case insensitively.
+// The keys of untagged fields are their Go names passed through mapper, if any. Of several keys matching a field
+// the last one in order wins, as encoding/json does, falling back to sorted order without one.
+func (p *primitivePlan) values(node map[string]interface{}, order []string, mapper func(string) string) []interface{} {
+	values := make([]interface{}, len(p.fields))
+	keys := make([]string, len(p.fields))
+	exact := make([]bool, len(p.fields))
+	matched := 0
+	for i, field := range p.fields {
+		values[i] = absent
+		keys[i] = field.name
+		if field.untagged && mapper != nil {
+			keys[i] = mapper(field.name)
+		}
+		if value, ok := node[keys[i]]; ok {
+			values[i], exact[i] = value, true
+			matched++
+		}
+	}
+	if matched == len(node) {
+		return values
+	}
+	hits := make([]int, len(p.fields))
+	collided := false
+	for key, value := range node {
+		i := p.foldedField(key)
+		if i < 0 || (exact[i] && key == keys[i]) {
+			continue
+		}
+		if hits[i]++; exact[i] || hits[i] > 1 {
+			collided = true
+			continue
+		}
+		values[i] = value
+	}
+	if !collided {
+		return values
+	}
+	if order == nil {
+		order = make([]string, 0, len(node))
+		for key := range node {
+			order = append(order, key)
+		}
+		sort.Strings(order)
+	}
+	exactFields := make(map[string]int, matched)
+	for i, key := range keys {
+		if exact[i] {
+			exactFields[key] = i
+		}
+	}
+	for _, key := range order {
+		i, ok := exactFields[key]
+		if !ok {
+			i = p.foldedField(key)
+		}
+		if i >= 0 {
+			values[i] = node[key]
+		}
+	}
+	return values
+}
+
+// foldedField - the index of the first field whose name the key matches case insensitively, -1 for none or for
+// the exact name of a field
+func (p *primitivePlan) foldedField(key string) int {
+	if _, ok := p.byName[key]; ok {
+		return -1
+	}
+	for i, field := range p.fields {
+		if strings.EqualFold(field.name, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// absent marks the fields without a key in the node
+var absent = new(struct{})
+
+// decodePrimitives - fills the primitive fields of the struct from the node without encoding it back to JSON.
+// Like json.Unmarshal, it keeps going past values of the wrong type, returning their errors in field order. The
+// order is the document order of the keys of the node, nil when not recorded.
+func decodePrimitives(node map[string]interface{}, order []string, modelValue reflect.Value,
+	o *options) ([]*json.UnmarshalTypeError, error) {
+	plan := primitivePlanOf(modelValue.Type())
+	var typeErrs []*json.UnmarshalTypeError
+	for i, value := range plan.values(node, order, o.fieldNameMapper) {
+		field := plan.fields[i]
+		if value == absent {
+			continue
+		}
+		fieldValue, ok := fieldByIndex(modelValue, field.index)
+		if !ok {
+			continue
+		}
+		err := decodeValue(fieldValue, field, value, o)
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			if typeErr.Field == "" {
+				typeErr.Field = field.name
+			} else {
+				typeErr.Field = field.name + "." + typeErr.Field
+			}
+			typeErr.Struct = plan.structName
+			typeErrs = append(typeErrs, typeErr)
+			continue
+		}
+		if err != nil {
+			return typeErrs, err
+		}
+	}
+	return typeErrs, nil
+}
+
+// hasRelationTag - reports whether the struct field carries a jsonsideload relation annotation
+func hasRelationTag(sf reflect.StructField) bool {
+	tag, ok := sf.Tag.Lookup(annotationJSONSideload)
+	return ok && isAnnotation(strings.Split(strings.Split(tag, modifierSeparator)[0], ",")[0])
+}
+
+// fieldByIndex - the field of the struct at index, allocating embedded pointers on the way
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() { // embedded pointer to an unexported struct
+					return v, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// decodeValue - sets the field to the decoded JSON value, parsing times with the configured layout
+func decodeValue(fieldValue reflect.Value, field primitiveField, value interface{}, o *options) error {
+	if s, ok := value.(string); ok && field.isTime && o.timeLayout != "" {
+		return setTime(fieldValue, s, o.timeLayout)
+	}
+	if number, ok := value.(json.Number); ok && field.textNumber {
+		return setText(fieldValue, string(number))
+	}
+	if o.looseScalars && !field.custom && !field.quoted && coerceScalar(fieldValue, value) {
+		return nil
+	}
+	return decodePrimitive(fieldValue, field, value)
+}
+
+// setText - decodes the text into a field whose type implements encoding.TextUnmarshaler, allocating a nil pointer
+func setText(fieldValue reflect.Value, text string) error {
+	if fieldValue.Kind() == reflect.Ptr {
+		if fieldValue.IsNil() {
+			fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+		}
+		return fieldValue.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+	}
+	return fieldValue.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+}
+
+// coerceScalar - sets a string field to a JSON number, or a numeric field to a string holding one, allocating a
+// pointer, and reports whether it did
+func coerceScalar(fieldValue reflect.Value, value interface{}) bool {
+	target := fieldValue
+	if fieldValue.Kind() == reflect.Ptr {
+		target = reflect.New(fieldValue.Type().Elem()).Elem()
+	}
+	switch v := value.(type) {
+	case json.Number:
+		if target.Kind() != reflect.String {
+			return false
+		}
+		target.SetString(string(v))
+	case string:
+		if !setNumber(target, json.Number(strings.TrimSpace(v))) {
+			return false
+		}
+	default:
+		return false
+	}
+	if fieldValue.Kind() == reflect.Ptr {
+		fieldValue.Set(target.Addr())
+	}
+	return true
+}
+
+// decodePrimitive - sets the field to the decoded JSON value, assigning common scalars directly
+func decodePrimitive(fieldValue reflect.Value, field primitiveField, value interface{}) error {
+	if field.quoted {
+		if s, ok := value.(string); ok {
+			return json.Unmarshal([]byte(s), fieldValue.Addr().Interface())
+		}
+	}
+	if !field.custom && !field.quoted {
+		switch v := value.(type) {
+		case nil:
+			switch fieldValue.Kind() {
+			case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+				fieldValue.Set(reflect.Zero(fieldValue.Type()))
+			}
+			return nil
+		case string:
+			if fieldValue.Kind() == reflect.String {
+				fieldValue.SetString(v)
+				return nil
+			}
+		case bool:
+			if fieldValue.Kind() == reflect.Bool {
+				fieldValue.SetBool(v)
+				return nil
+			}
+		case json.Number:
+			if setNumber(fieldValue, v) {
+				return nil
+			}
+		}
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, fieldValue.Addr().Interface())
+}
+
+// setTime - sets a time.Time or *time.Time field to the time parsed with layout
+func setTime(fieldValue reflect.Value, value, layout string) error {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return err
+	}
+	if fieldValue.Kind() == reflect.Ptr {
+		fieldValue.Set(reflect.ValueOf(&t))
+		return nil
+	}
+	fieldValue.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// setNumber - sets a numeric field to the number, reporting false when it does not fit
+func setNumber(fieldValue reflect.Value, number json.Number) bool {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(number), 10, 64)
+		if err != nil || fieldValue.OverflowInt(n) {
+			return false
+		}
+		fieldValue.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(string(number), 10, 64)
+		if err != nil || fieldValue.OverflowUint(n) {
+			return false
+		}
+		fieldValue.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(string(number), fieldValue.Type().Bits())
+		if err != nil || fieldValue.OverflowFloat(n) {
+			return false
+		}
+		fieldValue.SetFloat(n)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package jsonsideload
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePrimitives(t *testing.T) {
+	data := []byte(`{
+		"id": "42",
+		"TITLE": "Hello",
+		"score": 4.5,
+		"hidden": true,
+		"secret": "ignored",
+		"created_by": "ann",
+		"version": 3,
+		"number": 7
+	}`)
+	note := new(Note)
+	err := Unmarshal(data, note)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), note.ID)
+	assert.Equal(t, "Hello", note.Title)
+	assert.Equal(t, float32(4.5), note.Score)
+	assert.True(t, note.Hidden)
+	assert.Equal(t, "", note.secret)
+	assert.Equal(t, "ann", note.CreatedBy)
+	assert.Equal(t, 7, note.Revision.Number)
+	// version is ambiguous between the two embedded structs and is dropped, as encoding/json does
+	assert.Equal(t, 0, note.Audit.Version)
+	assert.Equal(t, 0, note.Revision.Version)
+
+	var expected Note
+	assert.Nil(t, json.Unmarshal(data, &expected))
+	assert.Equal(t, expected, *note)
+}
+
+func TestDecodePrimitivesFoldedKeys(t *testing.T) {
+	payloads := []string{
+		`{"Title": "a", "TITLE": "b"}`,
+		`{"TITLE": "b", "Title": "a"}`,
+		`{"title": "x", "TITLE": "y"}`,
+		`{"TITLE": "y", "title": "x", "Title": "z", "score": 1}`,
+	}
+	// the last key matching the field in document order wins every time, as with encoding/json
+	for i := 0; i < 100; i++ {
+		for _, payload := range payloads {
+			note := new(Note)
+			assert.Nil(t, Unmarshal([]byte(payload), note))
+			var expected Note
+			assert.Nil(t, json.Unmarshal([]byte(payload), &expected))
+			assert.Equal(t, expected.Title, note.Title, payload)
+		}
+	}
+
+	// sideloaded objects and top level arrays keep their key order as well
+	for i := 0; i < 100; i++ {
+		var notes []*Note
+		assert.Nil(t, Unmarshal([]byte(`[{"TITLE": "b", "Title": "a"}, {"Title": "a", "TITLE": "b"}]`), &notes))
+		assert.Equal(t, "a", notes[0].Title)
+		assert.Equal(t, "b", notes[1].Title)
+
+		subscription := new(Subscription)
+		err := Unmarshal([]byte(`{"id": "s_1", "account_id": "u_1", "accounts": [{"id": "u_1", "NAME": "b", "Name": "a"}]}`),
+			subscription)
+		assert.Nil(t, err)
+		assert.Equal(t, "a", subscription.Account.Name)
+
+		err = UnmarshalReader(strings.NewReader(`{"Title": "a", "TITLE": "b"}`), notes[0])
+		assert.Nil(t, err)
+		assert.Equal(t, "b", notes[0].Title)
+
+		// maps without a document order fall back to sorted keys
+		note := new(Note)
+		assert.Nil(t, UnmarshalMap(map[string]interface{}{"Title": "a", "TITLE": "b"}, note))
+		assert.Equal(t, "a", note.Title)
+	}
+}
+
+func TestDecodePrimitivesTypeError(t *testing.T) {
+	data := []byte(`{"title": 1, "score": "high", "hidden": true}`)
+	note := new(Note)
+	err := Unmarshal(data, note)
+	assert.EqualError(t, err, "Note: json: cannot unmarshal number into Go struct field Note.title of type string")
+	assert.True(t, note.Hidden)
+
+	var expected Note
+	assert.EqualError(t, json.Unmarshal(data, &expected), errors.Unwrap(err).Error())
+}
+
+func TestDecodePrimitivesSkipsRelations(t *testing.T) {
+	data := []byte(`{
+		"id": "s_1",
+		"plan": "gold",
+		"account": {"id": "u_999", "name": "Stale"},
+		"account_id": "u_404",
+		"managers": "not an array",
+		"accounts": []
+	}`)
+	subscription := new(Subscription)
+	err := Unmarshal(data, subscription)
+	assert.Nil(t, err)
+	assert.Equal(t, "gold", subscription.Plan)
+	assert.Nil(t, subscription.Account)
+	assert.Empty(t, subscription.Managers)
+}
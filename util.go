@@ -1,5 +1,16 @@
 package jsonsideload
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
 func IsRelationshipInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
@@ -8,3 +19,266 @@ func IsRelationshipInSlice(a string, list []string) bool {
 	}
 	return false
 }
+
+// canonicalID - the string an id is indexed by, the same for a number and its string, like 123, 123.0 and "123".
+// False for ids that are not scalars.
+func canonicalID(id interface{}) (string, bool) {
+	switch v := id.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case json.Number:
+		return canonicalNumber(string(v)), true
+	case float64:
+		return canonicalFloat(v), true
+	}
+	return "", false
+}
+
+// canonicalNumber - integers as they are, keeping their precision, and other numbers in their shortest form
+func canonicalNumber(number string) string {
+	if strings.Trim(strings.TrimPrefix(number, "-"), "0123456789") == "" && number != "-0" {
+		return number
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return number
+	}
+	return canonicalFloat(f)
+}
+
+// canonicalFloat - integral values in their exact decimal digits without a fraction or exponent, so that 1e17 matches
+// 100000000000000000, and others in their shortest form
+func canonicalFloat(f float64) string {
+	if f == math.Trunc(f) && !math.IsInf(f, 0) {
+		if f == 0 {
+			return "0" // not -0
+		}
+		return strconv.FormatFloat(f, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// isBlankID - reports whether the id references nothing: missing, null, an empty string or zero
+func isBlankID(id interface{}) bool {
+	switch v := id.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case json.Number:
+		f, err := v.Float64()
+		return err == nil && f == 0
+	case float64:
+		return v == 0
+	}
+	return false
+}
+
+// lookupID - the id of a sideloaded object under lookupKey, joining the values of compound keys
+func lookupID(object map[string]interface{}, lookupKey string) interface{} {
+	if !strings.Contains(lookupKey, compoundSeparator) {
+		return object[lookupKey]
+	}
+	parts := strings.Split(lookupKey, compoundSeparator)
+	values := make([]interface{}, len(parts))
+	for i, part := range parts {
+		values[i] = object[part]
+	}
+	return compoundID(values)
+}
+
+// compoundID - the single id standing for the values of a compound id, nil unless all of them are scalars
+func compoundID(values []interface{}) interface{} {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		part, ok := canonicalID(v)
+		if !ok {
+			return nil
+		}
+		parts[i] = part
+	}
+	id, err := json.Marshal(parts)
+	if err != nil {
+		return nil
+	}
+	return string(id)
+}
+
+// referenceID - the id of a relation reference, which is either the id itself or an object carrying it under lookupKey
+func referenceID(reference interface{}, lookupKey string) interface{} {
+	if referenceMap, ok := reference.(map[string]interface{}); ok {
+		return referenceMap[lookupKey]
+	}
+	return reference
+}
+
+// structType - the type decoded into for a relation of typ, which is either *T or T
+func structType(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Ptr {
+		return typ.Elem()
+	}
+	return typ
+}
+
+// assign - sets target to the decoded *T model, or to the T it points to for non pointer, non interface targets
+func assign(target, model reflect.Value) {
+	if target.Kind() == reflect.Ptr || target.Kind() == reflect.Interface {
+		target.Set(model)
+		return
+	}
+	target.Set(model.Elem())
+}
+
+// appendModel - appends the decoded *T model to a slice of *T or T
+func appendModel(models, model reflect.Value) reflect.Value {
+	models = reflect.Append(models, reflect.Zero(models.Type().Elem()))
+	assign(models.Index(models.Len()-1), model)
+	return models
+}
+
+// normalizeSource - the value in the shape of a parsed JSON payload, with objects keyed by string, []interface{}
+// arrays and json.Number numbers, converted from the maps, slices and numbers of other decoders
+func normalizeSource(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, string, bool, json.Number:
+		return v, nil
+	case float32, float64:
+		f := reflect.ValueOf(v).Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("unsupported number %v", f)
+		}
+		bitSize := 64
+		if _, ok := v.(float32); ok { // the shortest digits of the float32, 0.1 rather than 0.10000000149011612
+			bitSize = 32
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, bitSize)), nil
+	case int, int8, int16, int32, int64:
+		return json.Number(strconv.FormatInt(reflect.ValueOf(v).Int(), 10)), nil
+	case uint, uint8, uint16, uint32, uint64, uintptr:
+		return json.Number(strconv.FormatUint(reflect.ValueOf(v).Uint(), 10)), nil
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, element := range v {
+			var err error
+			if array[i], err = normalizeSource(element); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			var err error
+			if object[key], err = normalizeSource(element); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	case map[interface{}]interface{}: // like the maps of YAML decoders, keyed by the scalars of the document
+		object := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			var err error
+			if object[fmt.Sprint(key)], err = normalizeSource(element); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	}
+	// other values, like time.Time, as they encode to JSON
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSourceJSON(bytes.NewReader(raw))
+}
+
+// typeArgPackage matches the package path qualifying a type argument in the name of an instantiated generic type
+var typeArgPackage = regexp.MustCompile(`(?:[\w.\-]+/)*[\w\-]+\.`)
+
+// typeName - the name of the type, with the type arguments of a generic type left unqualified, like Page[Order]
+func typeName(t reflect.Type) string {
+	name := t.Name()
+	if !strings.Contains(name, "[") {
+		return name
+	}
+	return typeArgPackage.ReplaceAllString(name, "")
+}
+
+// indexPath - the path of the element at index i of the array at path, like Order.Items[2]
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+// relationSlice - an empty slice for the decoded models of a relation field, of the element type for fixed size arrays
+func relationSlice(fieldType reflect.Type, capacity int) reflect.Value {
+	if fieldType.Kind() == reflect.Array {
+		fieldType = reflect.SliceOf(fieldType.Elem())
+	}
+	return reflect.MakeSlice(fieldType, 0, capacity)
+}
+
+// setModels - sets the relation field to the decoded models, copied into a fixed size array which must hold them all
+func setModels(fieldValue, models reflect.Value) error {
+	if fieldValue.Kind() != reflect.Array {
+		fieldValue.Set(models)
+		return nil
+	}
+	if models.Len() > fieldValue.Len() {
+		return fmt.Errorf("%d relation items, more than the length %d of the array", models.Len(), fieldValue.Len())
+	}
+	fieldValue.Set(reflect.Zero(fieldValue.Type()))
+	reflect.Copy(fieldValue, models)
+	return nil
+}
+
+// setMapModel - stores the decoded *T model under key in a map of *T or T
+func setMapModel(models reflect.Value, key string, model reflect.Value) {
+	element := reflect.New(models.Type().Elem()).Elem()
+	assign(element, model)
+	models.SetMapIndex(reflect.ValueOf(key).Convert(models.Type().Key()), element)
+}
+
+// valueAtPath - the value reached by walking the keys of path through nested objects, nil if any is missing
+func valueAtPath(node map[string]interface{}, path []string) interface{} {
+	var v interface{} = node
+	for _, key := range path {
+		nodeMap, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = nodeMap[key]
+	}
+	return v
+}
+
+// jsonFieldName - the key encoding/json uses for the struct field, empty if it is skipped
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// isTruthy - reports whether the JSON value is set, which null, false, 0, "" and "false" are not
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false"
+	case json.Number:
+		f, err := v.Float64()
+		return err != nil || f != 0
+	case float64:
+		return v != 0
+	}
+	return true
+}
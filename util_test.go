@@ -0,0 +1,108 @@
+package jsonsideload
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssign(t *testing.T) {
+	model := reflect.ValueOf(&Tag{Name: "go"})
+
+	var pointer *Tag
+	assign(reflect.ValueOf(&pointer).Elem(), model)
+	assert.True(t, pointer == model.Interface().(*Tag))
+
+	var value Tag
+	assign(reflect.ValueOf(&value).Elem(), model)
+	assert.Equal(t, Tag{Name: "go"}, value)
+}
+
+func TestAppendModel(t *testing.T) {
+	model := reflect.ValueOf(&Tag{Name: "go"})
+
+	pointers := appendModel(reflect.ValueOf([]*Tag{}), model).Interface().([]*Tag)
+	assert.Len(t, pointers, 1)
+	assert.True(t, pointers[0] == model.Interface().(*Tag))
+
+	values := appendModel(reflect.ValueOf([]Tag{}), model).Interface().([]Tag)
+	assert.Equal(t, []Tag{{Name: "go"}}, values)
+}
+
+func TestCanonicalID(t *testing.T) {
+	for id, expected := range map[interface{}]string{
+		"123":                               "123",
+		"u_123":                             "u_123",
+		json.Number("123"):                  "123",
+		json.Number("123.0"):                "123",
+		json.Number("1.23e2"):               "123",
+		json.Number("1.5"):                  "1.5",
+		json.Number("-0"):                   "0",
+		json.Number("12345678901234567890"): "12345678901234567890",
+		json.Number("1e17"):                 "100000000000000000",
+		json.Number("1E6"):                  "1000000",
+		json.Number("1000000.000"):          "1000000",
+		json.Number("150e-2"):               "1.5",
+		json.Number("0.000001"):             "1e-06",
+		float64(123):                        "123",
+		float64(1e17):                       "100000000000000000",
+		float64(1 << 60):                    "1152921504606846976",
+		true:                                "true",
+	} {
+		canonical, ok := canonicalID(id)
+		assert.True(t, ok)
+		assert.Equal(t, expected, canonical, "%#v", id)
+	}
+	_, ok := canonicalID(map[string]interface{}{"id": 1})
+	assert.False(t, ok)
+	_, ok = canonicalID(nil)
+	assert.False(t, ok)
+}
+
+func TestNormalizeSource(t *testing.T) {
+	for value, expected := range map[interface{}]interface{}{
+		float32(0.1):       json.Number("0.1"),
+		float32(4.5):       json.Number("4.5"),
+		float64(0.1):       json.Number("0.1"),
+		int8(-3):           json.Number("-3"),
+		uint16(7):          json.Number("7"),
+		"u_123":            "u_123",
+		json.Number("1e3"): json.Number("1e3"),
+	} {
+		normalized, err := normalizeSource(value)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, normalized, "%#v", value)
+	}
+}
+
+func TestUnmarshalLargeAndExponentIDs(t *testing.T) {
+	person := new(Person)
+	err := Unmarshal([]byte(`{
+		"current_city_id": 1e17,
+		"lived_city_ids": [100000000000000000, "1000000", 1.5e0, 12345678901234567890],
+		"cities": [
+			{"id": 100000000000000000, "name": "big"},
+			{"id": 1e6, "name": "million"},
+			{"id": 1.50, "name": "fraction"},
+			{"id": 12345678901234567890, "name": "huge"}
+		]
+	}`), person)
+	assert.Nil(t, err)
+	assert.Equal(t, "big", person.CurrentCity.Name)
+	if assert.Len(t, person.LivedCities, 4) {
+		assert.Same(t, person.CurrentCity, person.LivedCities[0])
+		assert.Equal(t, "million", person.LivedCities[1].Name)
+		assert.Equal(t, "fraction", person.LivedCities[2].Name)
+		assert.Equal(t, "huge", person.LivedCities[3].Name)
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	assert.Equal(t, "Order", typeName(reflect.TypeOf(Order{})))
+	assert.Equal(t, "ResultPage[Order]", typeName(reflect.TypeOf(ResultPage[Order]{})))
+	assert.Equal(t, "Lookup[string,Customer]", typeName(reflect.TypeOf(Lookup[string, Customer]{})))
+	assert.Equal(t, "ResultPage[ResultPage[Time]]", typeName(reflect.TypeOf(ResultPage[ResultPage[time.Time]]{})))
+}
@@ -0,0 +1,227 @@
+package jsonsideload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// UnresolvedRef - a hasone or hasmany id without a sideloaded object, reported by Validate
+type UnresolvedRef struct {
+	// Relation is the name of the sideloaded array searched
+	Relation string
+	// LookupKey is the key of the sideloaded objects matched against the id
+	LookupKey string
+	// ID is the id that matched no sideloaded object
+	ID interface{}
+	// FieldPath is the struct field path of the relationship from the root model, like Order.Items[2]
+	FieldPath string
+}
+
+// Validate - walks the relations the tags of the model declare through the payload and reports every id without
+// a sideloaded object, without decoding into the model. The model is a pointer to struct, or a pointer to a slice
+// of pointers for a top level array, and is left untouched.
+func Validate(jsonPayload []byte, model interface{}, opts ...Option) ([]UnresolvedRef, error) {
+	source, err := decodeSourceJSON(bytes.NewReader(jsonPayload))
+	if err != nil {
+		return nil, fmt.Errorf("malformed JSON provided: %w", err)
+	}
+	o := newOptions(opts)
+	if o.storesErr != nil {
+		return nil, o.storesErr
+	}
+	if source, err = payloadRoot(source, o); err != nil {
+		return nil, err
+	}
+	modelType := reflect.TypeOf(model)
+	switch root := source.(type) {
+	case map[string]interface{}:
+		if _, err := rootModel(model); err != nil {
+			return nil, err
+		}
+		for modelType.Elem().Kind() == reflect.Ptr {
+			modelType = modelType.Elem()
+		}
+		v := &validator{decodeState: newDecodeState(context.Background(), o, root), visited: make(map[instanceKey]bool)}
+		defer o.addStats(&v.stats)
+		err := v.validateNode(root, modelType.Elem(), typeName(modelType.Elem()))
+		return v.refs, err
+	case []interface{}:
+		if modelType == nil || modelType.Kind() != reflect.Ptr || modelType.Elem().Kind() != reflect.Slice ||
+			modelType.Elem().Elem().Kind() != reflect.Ptr {
+			return nil, fmt.Errorf("expecting pointer to a slice of pointers for a top level array, got %T", model)
+		}
+		elementType := modelType.Elem().Elem().Elem()
+		var refs []UnresolvedRef
+		var stats Stats
+		defer o.addStats(&stats)
+		for i, element := range root {
+			elementMap, ok := element.(map[string]interface{})
+			if !ok {
+				return refs, fmt.Errorf("expecting an object at index %d of the top level array", i)
+			}
+			v := &validator{decodeState: newDecodeState(context.Background(), o, elementMap), visited: make(map[instanceKey]bool)}
+			err := v.validateNode(elementMap, elementType, indexPath(typeName(elementType), i))
+			stats.add(v.stats)
+			refs = append(refs, v.refs...)
+			if err != nil {
+				return refs, err
+			}
+		}
+		return refs, nil
+	}
+	return nil, fmt.Errorf("malformed JSON provided: expecting an object or an array, got %T", source)
+}
+
+// validator - the state of a Validate walk, looking relations up like a decode does
+type validator struct {
+	*decodeState
+	refs []UnresolvedRef
+	// visited holds the sideloaded objects walked so far, which also ends reference cycles
+	visited map[instanceKey]bool
+}
+
+// validateNode - walks the relations of the struct type through the node
+func (v *validator) validateNode(node map[string]interface{}, modelType reflect.Type, path string) error {
+	if modelType.Kind() != reflect.Struct {
+		return nil // types decoding themselves have no tags to follow
+	}
+	for _, field := range typePlan(modelType) {
+		fieldPath := path + "." + field.name
+		if field.err != nil {
+			return atField(fieldPath, field.err)
+		}
+		if (field.condition != "" && !isTruthy(node[field.condition])) || v.opts.skipsRelation(field) {
+			continue
+		}
+		fieldType := modelType.FieldByIndex(field.index).Type
+		var err error
+		switch field.annotation {
+		case annotationInclude, annotationIncludeFirst, annotationIncludeLast:
+			err = v.validateObject(field, fieldType, v.includedValue(field, node), fieldPath)
+		case annotationIncludes:
+			relation, _ := matchedValue(node, field.relation, v.opts.keyMatcher)
+			relationsArray, _ := relation.([]interface{})
+			for j, element := range relationsArray {
+				if err = v.validateObject(field, fieldType.Elem(), element, indexPath(fieldPath, j)); err != nil {
+					break
+				}
+			}
+		case annotationHasOneRelation:
+			if field.backReference != "" {
+				err = v.validateBackReferences(field, fieldType, node, fieldPath)
+				break
+			}
+			if len(field.candidates) > 0 {
+				if field.relation, err = v.candidateRelation(field, node, fieldPath); field.relation == "" {
+					break
+				}
+			}
+			var id interface{}
+			if id, err = v.singleID(field.idValue(node), fieldPath); err != nil {
+				break
+			}
+			relationID := referenceID(id, field.lookupKeyOr(v.opts.idField))
+			if !isBlankID(relationID) {
+				err = v.validateReference(field, fieldType, node, relationID, fieldPath)
+			}
+		case annotationHasManyRelation:
+			relationsArray, _ := v.relationIDs(field.idValue(node))
+			for j, n := range relationsArray {
+				n = referenceID(n, field.lookupKeyOr(v.opts.idField))
+				if isBlankID(n) {
+					continue
+				}
+				if err = v.validateReference(field, fieldType.Elem(), node, n, indexPath(fieldPath, j)); err != nil {
+					break
+				}
+			}
+		case annotationHasManyReverse:
+			err = v.validateBackReferences(field, fieldType.Elem(), node, fieldPath)
+		case annotationZip:
+			elements, _ := zippedElements(field, node, v.opts.keyMatcher)
+			for j, element := range elements {
+				if err = v.validateObject(field, fieldType.Elem(), element, indexPath(fieldPath, j)); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateObject - walks the relations of a nested object
+func (v *validator) validateObject(field fieldPlan, target reflect.Type, value interface{}, path string) error {
+	relationMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	modelType, err := v.modelType(field, target, relationMap, path)
+	if modelType == nil {
+		return err
+	}
+	return v.validateNode(relationMap, modelType, path)
+}
+
+// validateReference - reports the id if it has no sideloaded object, and otherwise walks the object once
+func (v *validator) validateReference(field fieldPlan, target reflect.Type, node map[string]interface{}, id interface{},
+	path string) error {
+	lookupKey, id := field.relationLookup(node, id, v.opts.idField)
+	relationMap, err := v.resolveRelation(field.relation, lookupKey, id, path)
+	if err != nil {
+		return err
+	}
+	if relationMap == nil {
+		v.refs = append(v.refs, UnresolvedRef{Relation: field.relation, LookupKey: lookupKey, ID: id, FieldPath: path})
+		return nil
+	}
+	return v.validateSideloaded(field, target, relationMap, id, path)
+}
+
+// validateBackReferences - walks the sideloaded objects pointing back at the node, reporting none found for a hasone
+func (v *validator) validateBackReferences(field fieldPlan, target reflect.Type, node map[string]interface{}, path string) error {
+	backReference, lookupKey := field.backReference, field.parentKey(v.opts.idField)
+	if field.annotation == annotationHasManyReverse {
+		backReference = field.idField
+	}
+	parentID, ok := canonicalID(node[lookupKey])
+	if !ok {
+		return nil
+	}
+	matches := v.getBackReferences(field.relation, backReference, parentID)
+	v.stats.Resolved += len(matches)
+	if len(matches) == 0 && field.annotation == annotationHasOneRelation {
+		v.refs = append(v.refs, UnresolvedRef{Relation: field.relation, LookupKey: backReference, ID: node[lookupKey], FieldPath: path})
+	}
+	for j, relationMap := range matches {
+		elementPath := path
+		if field.annotation == annotationHasManyReverse {
+			elementPath = indexPath(path, j)
+		}
+		if err := v.validateSideloaded(field, target, relationMap, relationMap[v.opts.idField], elementPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSideloaded - walks the relations of a sideloaded object, once per object
+func (v *validator) validateSideloaded(field fieldPlan, target reflect.Type, relationMap map[string]interface{}, id interface{},
+	path string) error {
+	modelType, err := v.modelType(field, target, relationMap, path)
+	if modelType == nil {
+		return err
+	}
+	if canonical, ok := canonicalID(id); ok {
+		key := instanceKey{field.relation, canonical, modelType}
+		if v.visited[key] {
+			return nil
+		}
+		v.visited[key] = true
+	}
+	return v.validateNode(relationMap, modelType, path)
+}
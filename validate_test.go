@@ -0,0 +1,40 @@
+package jsonsideload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	data := []byte(`{
+		"subscriptions": [
+			{"id": "s_1", "account_id": "u_123", "manager_ids": ["u_404", "u_123"]},
+			{"id": "s_2", "account_id": "u_405"}
+		],
+		"accounts": [{"id": "u_123", "name": "Acme"}]
+	}`)
+	resp := new(SubscriptionResponse)
+	refs, err := Validate(data, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, []UnresolvedRef{
+		{Relation: "accounts", LookupKey: "id", ID: "u_404", FieldPath: "SubscriptionResponse.Subscriptions[0].Managers[0]"},
+		{Relation: "accounts", LookupKey: "id", ID: "u_405", FieldPath: "SubscriptionResponse.Subscriptions[1].Account"},
+	}, refs)
+	assert.Nil(t, resp.Subscriptions)
+
+	// cycles end at objects already walked
+	refs, err = Validate([]byte(`{
+		"id": 1,
+		"customer_id": 7,
+		"customers": [{"id": 7, "order_ids": [1, 2]}],
+		"orders": [{"id": 1, "customer_id": 7}]
+	}`), new(Order))
+	assert.Nil(t, err)
+	if assert.Len(t, refs, 1) {
+		assert.Equal(t, "Order.Customer.Orders[1]", refs[0].FieldPath)
+	}
+
+	_, err = Validate([]byte(`{"account_id": "u_1"}`), new(MissingHasOneIDField))
+	assert.EqualError(t, err, "MissingHasOneIDField.Account: hasone relation Account requires an id field argument")
+}